@@ -0,0 +1,41 @@
+package ch3
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes the delays between retries: Initial before the first
+// retry, doubling with every retry after that up to Max, each randomly
+// lengthened or shortened by up to Jitter of itself.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration // defaults to 32 times Initial
+	Jitter  float64       // 0.1 means ±10%
+}
+
+// Delay returns the delay before retry n, counting from 1.
+func (b Backoff) Delay(n int) time.Duration {
+	max := b.Max
+	if max <= 0 {
+		max = 32 * b.Initial
+	}
+	d := b.Initial
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return jitter(d, b.Jitter)
+}
+
+// jitter randomly adjusts d by up to fraction of itself in either
+// direction, so that clients started together don't retry in lockstep.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return d
+	}
+	// 在[-fraction, +fraction)范围内随机调整
+	return d + time.Duration((rand.Float64()*2-1)*fraction*float64(d))
+}
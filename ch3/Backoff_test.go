@@ -0,0 +1,28 @@
+package ch3
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	expected := []time.Duration{10, 20, 40, 50, 50}
+	for i, e := range expected {
+		if actual := b.Delay(i + 1); actual != e*time.Millisecond {
+			t.Errorf("retry %d: expected %s; actual %s", i+1, e*time.Millisecond, actual)
+		}
+	}
+
+	// Max默认为Initial的32倍
+	if actual := (Backoff{Initial: time.Millisecond}).Delay(100); actual != 32*time.Millisecond {
+		t.Errorf("expected the default maximum of 32ms; actual %s", actual)
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := b.Delay(1); d < 5*time.Millisecond || d >= 15*time.Millisecond {
+			t.Fatalf("expected 10ms ±50%%; actual %s", d)
+		}
+	}
+}
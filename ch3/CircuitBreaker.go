@@ -0,0 +1,223 @@
+package ch3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned, wrapped, instead of dialing an address whose
+// circuit is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerState is the state of the circuit for one address.
+type BreakerState int
+
+const (
+	Closed   BreakerState = iota // dials go through
+	Open                         // dials fail right away until the cool-down ends
+	HalfOpen                     // a few probe dials decide whether to close again
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+// CircuitBreaker stops dialing an address after it failed too often, so
+// callers fail at once instead of each waiting out a dial timeout while the
+// address is down. Every address has its own circuit.
+//
+// A circuit opens after FailureThreshold consecutive failed dials. After
+// CoolDown it turns half-open and lets up to HalfOpenProbes dials through:
+// the first to succeed closes the circuit, and a failure opens it again.
+type CircuitBreaker struct {
+	FailureThreshold int           // consecutive failures that open a circuit; defaults to 5
+	CoolDown         time.Duration // how long a circuit stays open; defaults to 10s
+	HalfOpenProbes   int           // dials allowed at once while half-open; defaults to 1
+
+	// IsFailure reports whether a dial error counts against the address.
+	// It defaults to counting every error except the caller canceling
+	// the dial.
+	IsFailure func(error) bool
+
+	// OnStateChange, if set, is called when the circuit of an address
+	// changes state.
+	OnStateChange func(address string, from, to BreakerState)
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state    BreakerState
+	failures int       // consecutive failures while closed
+	openedAt time.Time // when the circuit last opened
+	probes   int       // dials in flight while half-open
+	gen      int       // counts state changes, to ignore dials started before one
+}
+
+// Wrap returns a dial function that dials through dial, or net.Dialer's
+// DialContext if dial is nil, unless the address's circuit is open. It has
+// the signature of the Dial fields used across the repository, such as the
+// ch4 proxy's.
+func (b *CircuitBreaker) Wrap(
+	dial func(ctx context.Context, network, address string) (net.Conn, error),
+) func(ctx context.Context, network, address string) (net.Conn, error) {
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		gen, err := b.allow(address)
+		if err != nil {
+			return nil, fmt.Errorf("dial %s %s: %w", network, address, err)
+		}
+		conn, err := dial(ctx, network, address)
+		b.done(address, gen, err)
+		return conn, err
+	}
+}
+
+// State returns the state of the circuit for address.
+func (b *CircuitBreaker) State(address string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[address]
+	if !ok {
+		return Closed
+	}
+	if c.state == Open && time.Since(c.openedAt) >= b.coolDown() {
+		return HalfOpen
+	}
+	return c.state
+}
+
+// allow decides whether a dial to address may go ahead and returns the
+// generation of the circuit it was allowed in.
+func (b *CircuitBreaker) allow(address string) (int, error) {
+	b.mu.Lock()
+	c := b.circuit(address)
+	var change func()
+	defer func() {
+		b.mu.Unlock()
+		if change != nil {
+			change()
+		}
+	}()
+
+	if c.state == Open {
+		wait := b.coolDown() - time.Since(c.openedAt)
+		if wait > 0 {
+			return 0, fmt.Errorf("%w; retry in %s", ErrCircuitOpen, wait.Round(time.Millisecond))
+		}
+		change = b.setState(address, c, HalfOpen)
+	}
+	if c.state == HalfOpen {
+		probes := b.HalfOpenProbes
+		if probes <= 0 {
+			probes = 1
+		}
+		if c.probes >= probes {
+			return 0, fmt.Errorf("%w; waiting for %d probes", ErrCircuitOpen, c.probes)
+		}
+		c.probes++
+	}
+	return c.gen, nil
+}
+
+// done records the outcome of a dial allowed in generation gen.
+func (b *CircuitBreaker) done(address string, gen int, err error) {
+	b.mu.Lock()
+	c := b.circuit(address)
+	var change func()
+	defer func() {
+		b.mu.Unlock()
+		if change != nil {
+			change()
+		}
+	}()
+
+	// 状态已经改变，之前开始的拨号结果不再有意义
+	if gen != c.gen {
+		return
+	}
+	failed := err != nil && b.isFailure(err)
+	switch c.state {
+	case Closed:
+		if !failed {
+			c.failures = 0
+			return
+		}
+		c.failures++
+		threshold := b.FailureThreshold
+		if threshold <= 0 {
+			threshold = 5
+		}
+		if c.failures >= threshold {
+			change = b.setState(address, c, Open)
+		}
+	case HalfOpen:
+		c.probes--
+		switch {
+		case failed:
+			change = b.setState(address, c, Open)
+		case err == nil:
+			change = b.setState(address, c, Closed)
+		}
+	}
+}
+
+// setState moves c to state and returns the callback to run once b.mu is
+// unlocked.
+func (b *CircuitBreaker) setState(address string, c *circuit, state BreakerState) func() {
+	from := c.state
+	c.state = state
+	c.gen++
+	c.failures = 0
+	c.probes = 0
+	if state == Open {
+		c.openedAt = time.Now()
+	}
+	if b.OnStateChange == nil {
+		return nil
+	}
+	return func() { b.OnStateChange(address, from, state) }
+}
+
+func (b *CircuitBreaker) circuit(address string) *circuit {
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+	c, ok := b.circuits[address]
+	if !ok {
+		c = new(circuit)
+		b.circuits[address] = c
+	}
+	return c
+}
+
+func (b *CircuitBreaker) coolDown() time.Duration {
+	if b.CoolDown <= 0 {
+		return 10 * time.Second
+	}
+	return b.CoolDown
+}
+
+func (b *CircuitBreaker) isFailure(err error) bool {
+	if b.IsFailure != nil {
+		return b.IsFailure(err)
+	}
+	return !errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,161 @@
+package ch3
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	// 和DialTimeout一样，用Control模拟一个无法访问的监听器，每次拨号都要等到超时
+	var unreachable atomic.Bool
+	var dials atomic.Int32
+	unreachable.Store(true)
+	d := net.Dialer{
+		Control: func(_, addr string, _ syscall.RawConn) error {
+			dials.Add(1)
+			if !unreachable.Load() {
+				return nil
+			}
+			time.Sleep(50 * time.Millisecond)
+			return &net.DNSError{
+				Err:         "connection timed out",
+				Name:        addr,
+				Server:      "127.0.0.1",
+				IsTimeout:   true,
+				IsTemporary: true,
+			}
+		},
+	}
+
+	var mu sync.Mutex
+	var changes []BreakerState
+	b := &CircuitBreaker{
+		FailureThreshold: 3,
+		CoolDown:         200 * time.Millisecond,
+		HalfOpenProbes:   1,
+		OnStateChange: func(_ string, _, to BreakerState) {
+			mu.Lock()
+			changes = append(changes, to)
+			mu.Unlock()
+		},
+	}
+	dial := b.Wrap(d.DialContext)
+	addr := listener.Addr().String()
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := dial(ctx, "tcp", addr)
+		var nErr net.Error
+		if !errors.As(err, &nErr) || !nErr.Timeout() {
+			t.Fatalf("dial %d: expected a timeout; actual %v", i, err)
+		}
+	}
+	if s := b.State(addr); s != Open {
+		t.Fatalf("expected an open circuit; actual %s", s)
+	}
+
+	// 断路器打开后立即失败，不再拨号
+	begin := time.Now()
+	_, err = dial(ctx, "tcp", addr)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen; actual %v", err)
+	}
+	if time.Since(begin) > 10*time.Millisecond || dials.Load() != 3 {
+		t.Errorf("expected no dial while open; %d dials in %s", dials.Load(), time.Since(begin))
+	}
+
+	// 冷却之后只允许一个探测，探测失败时断路器重新打开
+	time.Sleep(200 * time.Millisecond)
+	if s := b.State(addr); s != HalfOpen {
+		t.Fatalf("expected a half-open circuit; actual %s", s)
+	}
+	probe := make(chan error)
+	go func() {
+		_, err := dial(ctx, "tcp", addr)
+		probe <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if _, err = dial(ctx, "tcp", addr); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected a second probe to be rejected; actual %v", err)
+	}
+	if err = <-probe; err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the probe to time out; actual %v", err)
+	}
+	if s := b.State(addr); s != Open {
+		t.Fatalf("expected the failed probe to reopen the circuit; actual %s", s)
+	}
+
+	// 监听器恢复后，探测成功使断路器关闭
+	unreachable.Store(false)
+	time.Sleep(200 * time.Millisecond)
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if s := b.State(addr); s != Closed {
+		t.Fatalf("expected a closed circuit; actual %s", s)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []BreakerState{Open, HalfOpen, Open, HalfOpen, Closed}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected state changes %v; actual %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Fatalf("expected state changes %v; actual %v", expected, changes)
+		}
+	}
+}
+
+func TestCircuitBreakerPerAddress(t *testing.T) {
+	b := &CircuitBreaker{FailureThreshold: 1}
+	dial := b.Wrap(func(_ context.Context, _, address string) (net.Conn, error) {
+		if address == "down:1" {
+			return nil, syscall.ECONNREFUSED
+		}
+		c, _ := net.Pipe()
+		return c, nil
+	})
+
+	_, _ = dial(context.Background(), "tcp", "down:1")
+	if _, err := dial(context.Background(), "tcp", "down:1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen; actual %v", err)
+	}
+	conn, err := dial(context.Background(), "tcp", "up:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	// 调用方取消的拨号不算失败
+	_, _ = b.Wrap(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return nil, context.Canceled
+	})(context.Background(), "tcp", "up:1")
+	if s := b.State("up:1"); s != Closed {
+		t.Errorf("expected a canceled dial to leave the circuit closed; actual %s", s)
+	}
+}
@@ -0,0 +1,403 @@
+package ch3
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrPoolClosed = errors.New("connection pool closed")
+
+// PoolStats counts what a ConnPool has done.
+type PoolStats struct {
+	Hits      int64 // Gets served with an idle connection
+	Misses    int64 // Gets that dialed a new connection
+	Evictions int64 // idle connections closed as stale, broken or surplus
+	Open      int   // connections open now, idle or in use
+	Idle      int   // idle connections now
+}
+
+// ConnPool keeps connections to each address open for reuse, so a client
+// that makes many requests doesn't pay for a new connection each time.
+// Connections from Get go back to the pool when closed, unless a read or
+// write on them failed.
+type ConnPool struct {
+	MaxIdle     int           // idle connections kept per address; defaults to 2
+	MaxOpen     int           // connections per address, idle or in use; 0 means no limit
+	IdleTimeout time.Duration // close connections idle this long; 0 means never
+	MaxLifetime time.Duration // close connections this old instead of reusing them; 0 means never
+
+	// Validate checks an idle connection before Get hands it out. It
+	// defaults to CheckConn.
+	Validate func(net.Conn) error
+
+	// Dial connects to an address. It defaults to net.Dialer's DialContext.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	mu     sync.Mutex
+	addrs  map[string]*addrPool
+	stats  PoolStats
+	closed bool
+}
+
+type addrPool struct {
+	idle []idleConn
+	open int
+	wait []chan struct{} // Gets waiting for a connection to free up
+}
+
+// idleConn is a connection waiting in the pool. Get wraps it in a new
+// PooledConn each time, so a handle closed once can't return it again.
+type idleConn struct {
+	conn      net.Conn
+	created   time.Time
+	idleSince time.Time
+}
+
+// PooledConn is a connection from a ConnPool. Once closed, it stays
+// closed even after the pool hands the connection to someone else.
+type PooledConn struct {
+	net.Conn
+	pool    *ConnPool
+	key     string
+	created time.Time
+
+	mu       sync.Mutex
+	err      error
+	returned bool
+	active   int           // reads, writes and deadline changes in progress
+	drained  chan struct{} // closed when the last of them ends after Close
+}
+
+// Get returns an idle connection to address if there's a healthy one, or
+// dials a new one. If MaxOpen connections to address are open, Get waits
+// for one to be closed or for ctx to be done.
+func (p *ConnPool) Get(ctx context.Context, network, address string) (*PooledConn, error) {
+	key := network + " " + address
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		ap := p.addrPool(key)
+
+		if n := len(ap.idle); n > 0 {
+			// 最近放回的连接最可能仍然可用
+			c := ap.idle[n-1]
+			ap.idle = ap.idle[:n-1]
+			p.mu.Unlock()
+			if err := p.check(c); err != nil {
+				p.evict(key, c.conn)
+				continue
+			}
+			p.mu.Lock()
+			p.stats.Hits++
+			p.mu.Unlock()
+			return &PooledConn{Conn: c.conn, pool: p, key: key, created: c.created}, nil
+		}
+
+		if p.MaxOpen <= 0 || ap.open < p.MaxOpen {
+			ap.open++
+			p.stats.Misses++
+			p.mu.Unlock()
+			conn, err := p.dial(ctx, network, address)
+			if err != nil {
+				p.mu.Lock()
+				ap.open--
+				p.notify(ap)
+				p.mu.Unlock()
+				return nil, err
+			}
+			return &PooledConn{Conn: conn, pool: p, key: key, created: time.Now()}, nil
+		}
+
+		ready := make(chan struct{}, 1)
+		ap.wait = append(ap.wait, ready)
+		p.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			p.mu.Lock()
+			for i, ch := range ap.wait {
+				if ch == ready {
+					ap.wait = append(ap.wait[:i], ap.wait[i+1:]...)
+					break
+				}
+			}
+			// 已经收到通知的话，把机会让给下一个等待者
+			select {
+			case <-ready:
+				p.notify(ap)
+			default:
+			}
+			p.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// check reports why c can't be reused, if it can't.
+func (p *ConnPool) check(c idleConn) error {
+	now := time.Now()
+	if p.IdleTimeout > 0 && now.Sub(c.idleSince) >= p.IdleTimeout {
+		return errors.New("idle too long")
+	}
+	if p.MaxLifetime > 0 && now.Sub(c.created) >= p.MaxLifetime {
+		return errors.New("too old")
+	}
+	if p.Validate != nil {
+		return p.Validate(c.conn)
+	}
+	return CheckConn(c.conn)
+}
+
+// CheckConn reports whether an idle connection is still usable by reading
+// from it with a very short deadline. A healthy idle connection has
+// nothing to read, so the read times out; a connection the peer closed
+// returns io.EOF. Data the peer sent unasked also makes the connection
+// unusable, since it would be mistaken for the reply to the next request.
+//
+// Go returns at once from zero-byte reads without asking the kernel, so
+// CheckConn has to read one byte.
+func CheckConn(conn net.Conn) error {
+	err := conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	if err != nil {
+		return err
+	}
+	n, err := conn.Read(make([]byte, 1))
+	_ = conn.SetReadDeadline(time.Time{})
+	var nErr net.Error
+	switch {
+	case n > 0:
+		return errors.New("unexpected data on idle connection")
+	case errors.As(err, &nErr) && nErr.Timeout():
+		return nil
+	case err == nil:
+		return errors.New("idle connection returned no data and no error")
+	default:
+		return err
+	}
+}
+
+// put takes c back, keeping it idle if it's healthy and there's room.
+func (p *ConnPool) put(c *PooledConn, err error) error {
+	p.mu.Lock()
+	ap := p.addrPool(c.key)
+	reuse := err == nil && !p.closed && len(ap.idle) < p.maxIdle() &&
+		(p.MaxLifetime <= 0 || time.Since(c.created) < p.MaxLifetime)
+	if reuse {
+		// 清除使用者设置的截止时间，以免影响下一个使用者
+		_ = c.Conn.SetDeadline(time.Time{})
+		ap.idle = append(ap.idle, idleConn{conn: c.Conn, created: c.created, idleSince: time.Now()})
+		p.notify(ap)
+		p.mu.Unlock()
+		return nil
+	}
+	if err == nil {
+		p.stats.Evictions++
+	}
+	ap.open--
+	p.notify(ap)
+	p.mu.Unlock()
+	return c.Conn.Close()
+}
+
+func (p *ConnPool) evict(key string, conn net.Conn) {
+	_ = conn.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.Evictions++
+	ap := p.addrPool(key)
+	ap.open--
+	p.notify(ap)
+}
+
+// notify wakes the first Get waiting for a connection to ap. p.mu must be
+// held.
+func (p *ConnPool) notify(ap *addrPool) {
+	if len(ap.wait) == 0 {
+		return
+	}
+	ap.wait[0] <- struct{}{}
+	ap.wait = ap.wait[1:]
+}
+
+func (p *ConnPool) addrPool(key string) *addrPool {
+	if p.addrs == nil {
+		p.addrs = make(map[string]*addrPool)
+	}
+	ap, ok := p.addrs[key]
+	if !ok {
+		ap = new(addrPool)
+		p.addrs[key] = ap
+	}
+	return ap
+}
+
+func (p *ConnPool) maxIdle() int {
+	if p.MaxIdle <= 0 {
+		return 2
+	}
+	return p.MaxIdle
+}
+
+func (p *ConnPool) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if p.Dial != nil {
+		return p.Dial(ctx, network, address)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+// Stats returns the pool's counters.
+func (p *ConnPool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats
+	for _, ap := range p.addrs {
+		s.Open += ap.open
+		s.Idle += len(ap.idle)
+	}
+	return s
+}
+
+// Close closes the idle connections and makes Get fail. Connections in use
+// are closed when they're returned.
+func (p *ConnPool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	var idle []idleConn
+	for _, ap := range p.addrs {
+		idle = append(idle, ap.idle...)
+		ap.open -= len(ap.idle)
+		ap.idle = nil
+		for len(ap.wait) > 0 {
+			p.notify(ap)
+		}
+	}
+	p.mu.Unlock()
+
+	var errs []error
+	for _, c := range idle {
+		errs = append(errs, c.conn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (c *PooledConn) Read(b []byte) (int, error) {
+	if !c.begin() {
+		return 0, net.ErrClosed
+	}
+	n, err := c.Conn.Read(b)
+	c.end(err)
+	return n, err
+}
+
+func (c *PooledConn) Write(b []byte) (int, error) {
+	if !c.begin() {
+		return 0, net.ErrClosed
+	}
+	n, err := c.Conn.Write(b)
+	c.end(err)
+	return n, err
+}
+
+// SetDeadline, SetReadDeadline and SetWriteDeadline fail once the handle
+// is closed, so they can't change the deadline of whoever uses the
+// connection next.
+func (c *PooledConn) SetDeadline(t time.Time) error {
+	if !c.begin() {
+		return net.ErrClosed
+	}
+	defer c.end(nil)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *PooledConn) SetReadDeadline(t time.Time) error {
+	if !c.begin() {
+		return net.ErrClosed
+	}
+	defer c.end(nil)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *PooledConn) SetWriteDeadline(t time.Time) error {
+	if !c.begin() {
+		return net.ErrClosed
+	}
+	defer c.end(nil)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// MarkBroken keeps the connection from going back to the pool, for errors
+// the pool can't see, such as a malformed reply.
+func (c *PooledConn) MarkBroken(err error) {
+	if err == nil {
+		err = errors.New("marked broken")
+	}
+	c.fail(err)
+}
+
+func (c *PooledConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// begin registers an operation on the connection, or returns false if the
+// handle is closed. Close waits for registered operations to end before
+// returning the connection to the pool.
+func (c *PooledConn) begin() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.returned {
+		return false
+	}
+	c.active++
+	return true
+}
+
+func (c *PooledConn) end(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	c.active--
+	if c.active == 0 && c.drained != nil {
+		close(c.drained)
+	}
+}
+
+// Close returns the connection to the pool, or closes it if a read or
+// write failed, it was marked broken or the pool has no room for it.
+func (c *PooledConn) Close() error {
+	c.mu.Lock()
+	if c.returned {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.returned = true
+	var drained chan struct{}
+	if c.active > 0 {
+		// 还有读写没有结束，打断它们；连接上的数据状态未知，不能再复用
+		if c.err == nil {
+			c.err = errors.New("closed during a read or write")
+		}
+		drained = make(chan struct{})
+		c.drained = drained
+	}
+	err := c.err
+	c.mu.Unlock()
+	if drained != nil {
+		_ = c.Conn.SetDeadline(time.Now())
+		<-drained
+	}
+	return c.pool.put(c, err)
+}
@@ -0,0 +1,270 @@
+package ch3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// poolServer echoes on every connection and lets the test close them from
+// the server side.
+func poolServer(t *testing.T) (net.Listener, func()) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go func() { _, _ = io.Copy(conn, conn) }()
+		}
+	}()
+	t.Cleanup(func() { _ = listener.Close() })
+	return listener, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range conns {
+			_ = c.Close()
+		}
+		conns = nil
+	}
+}
+
+func echo(t *testing.T, c net.Conn, msg string) {
+	t.Helper()
+	_, err := c.Write([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(c, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != msg {
+		t.Fatalf("expected %q; actual %q", msg, buf)
+	}
+}
+
+func TestConnPoolReuse(t *testing.T) {
+	listener, closeAll := poolServer(t)
+	addr := listener.Addr().String()
+	p := new(ConnPool)
+	defer p.Close()
+	ctx := context.Background()
+
+	c, err := p.Get(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, c, "one")
+	local := c.LocalAddr().String()
+	_ = c.Close()
+
+	c, err = p.Get(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.LocalAddr().String() != local {
+		t.Error("expected the idle connection to be reused")
+	}
+	echo(t, c, "two")
+	_ = c.Close()
+	if err = c.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected a second Close to fail; actual %v", err)
+	}
+
+	// 已关闭的句柄不能把别人正在用的连接放回连接池
+	stale := c
+	b, err := p.Get(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == stale {
+		t.Error("expected Get to return a new handle")
+	}
+	if err = stale.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected the stale handle to stay closed; actual %v", err)
+	}
+	if _, err = stale.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected writes on the stale handle to fail; actual %v", err)
+	}
+	if _, err = stale.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected reads on the stale handle to fail; actual %v", err)
+	}
+	past := time.Now().Add(-time.Second)
+	if err = stale.SetDeadline(past); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected SetDeadline on the stale handle to fail; actual %v", err)
+	}
+	if err = stale.SetReadDeadline(past); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected SetReadDeadline on the stale handle to fail; actual %v", err)
+	}
+	if err = stale.SetWriteDeadline(past); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected SetWriteDeadline on the stale handle to fail; actual %v", err)
+	}
+	if s := p.Stats(); s.Idle != 0 || s.Open != 1 {
+		t.Errorf("expected the connection to stay in use; actual %+v", s)
+	}
+	echo(t, b, "again")
+	_ = b.Close()
+
+	// 服务器关闭了空闲连接，取出时的检查会发现并重新拨号
+	closeAll()
+	time.Sleep(50 * time.Millisecond)
+	c, err = p.Get(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.LocalAddr().String() == local {
+		t.Error("expected a new connection")
+	}
+	echo(t, c, "three")
+
+	// 读写出错的连接不会放回连接池
+	c.MarkBroken(nil)
+	_ = c.Close()
+
+	s := p.Stats()
+	if s.Hits != 2 || s.Misses != 2 || s.Evictions != 1 || s.Open != 0 || s.Idle != 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestConnPoolCloseDuringRead(t *testing.T) {
+	listener, _ := poolServer(t)
+	addr := listener.Addr().String()
+	p := new(ConnPool)
+	defer p.Close()
+	ctx := context.Background()
+
+	c, err := p.Get(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := c.LocalAddr().String()
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	// Close打断阻塞的Read，被打断的连接不能回到连接池
+	time.Sleep(50 * time.Millisecond)
+	_ = c.Close()
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Error("expected the interrupted Read to fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close didn't interrupt the blocked Read")
+	}
+	if s := p.Stats(); s.Idle != 0 || s.Open != 0 {
+		t.Errorf("expected the interrupted connection to be closed; actual %+v", s)
+	}
+
+	c, err = p.Get(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.LocalAddr().String() == local {
+		t.Error("expected a new connection")
+	}
+	echo(t, c, "fresh")
+	_ = c.Close()
+}
+
+func TestConnPoolMaxOpen(t *testing.T) {
+	listener, _ := poolServer(t)
+	addr := listener.Addr().String()
+	p := &ConnPool{MaxOpen: 1}
+	defer p.Close()
+
+	c, err := p.Get(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = p.Get(ctx, "tcp", addr); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected Get to wait until the deadline; actual %v", err)
+	}
+
+	// 连接放回后，等待中的Get得到这个连接
+	got := make(chan *PooledConn)
+	go func() {
+		c, err := p.Get(context.Background(), "tcp", addr)
+		if err != nil {
+			t.Error(err)
+		}
+		got <- c
+	}()
+	time.Sleep(20 * time.Millisecond)
+	local := c.LocalAddr().String()
+	_ = c.Close()
+	c = <-got
+	if c == nil {
+		t.FailNow()
+	}
+	if c.LocalAddr().String() != local {
+		t.Error("expected the waiting Get to reuse the returned connection")
+	}
+	_ = c.Close()
+}
+
+func TestConnPoolExpiry(t *testing.T) {
+	listener, _ := poolServer(t)
+	addr := listener.Addr().String()
+	p := &ConnPool{MaxIdle: 1, IdleTimeout: 50 * time.Millisecond, MaxLifetime: 150 * time.Millisecond}
+	ctx := context.Background()
+
+	a, err := p.Get(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.Get(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Close()
+	_ = b.Close() // 超过MaxIdle，直接关闭
+	if s := p.Stats(); s.Idle != 1 || s.Open != 1 || s.Evictions != 1 {
+		t.Fatalf("expected one idle connection; actual %+v", s)
+	}
+
+	// 空闲太久的连接被关闭
+	time.Sleep(60 * time.Millisecond)
+	c, err := p.Get(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := p.Stats(); s.Hits != 0 || s.Evictions != 2 {
+		t.Fatalf("expected the idle connection to expire; actual %+v", s)
+	}
+
+	// 超过最长寿命的连接放回时被关闭
+	time.Sleep(160 * time.Millisecond)
+	_ = c.Close()
+	if s := p.Stats(); s.Idle != 0 || s.Open != 0 || s.Evictions != 3 {
+		t.Fatalf("expected the old connection to be closed; actual %+v", s)
+	}
+
+	_ = p.Close()
+	if _, err = p.Get(ctx, "tcp", addr); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("expected ErrPoolClosed; actual %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package ch3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Dialer connects to an address, retrying failures that may go away with
+// exponential backoff and jitter.
+type Dialer struct {
+	Attempts       int           // attempts before giving up; defaults to 3
+	AttemptTimeout time.Duration // limit on each attempt, apart from the context's deadline; 0 means none
+
+	// Backoff is the delay before the first retry. It doubles after every
+	// failed attempt, up to MaxBackoff. It defaults to 100ms.
+	Backoff    time.Duration
+	MaxBackoff time.Duration // defaults to 32 times Backoff
+
+	// Jitter randomly lengthens or shortens every delay by up to this
+	// fraction of it, so clients that failed together don't retry
+	// together. 0.1 means ±10%.
+	Jitter float64
+
+	// Retryable reports whether a failed attempt is worth retrying. It
+	// defaults to retrying timeouts, temporary errors and refused or reset
+	// connections.
+	Retryable func(error) bool
+
+	// Dial makes each attempt. It defaults to net.Dialer's DialContext.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// DialAttempt is the outcome of one failed attempt.
+type DialAttempt struct {
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// DialError is returned by Dialer when every attempt failed. It unwraps
+// to the error of every attempt.
+type DialError struct {
+	Network, Address string
+	Attempts         []DialAttempt
+
+	// Err is why the Dialer stopped: the last attempt's error, or the
+	// context's error if it was canceled between attempts.
+	Err error
+}
+
+func (e *DialError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "dial %s %s: %d failed attempts: %v", e.Network, e.Address, len(e.Attempts), e.Err)
+	if len(e.Attempts) > 1 {
+		b.WriteString(" (")
+		for i, a := range e.Attempts {
+			if i > 0 {
+				b.WriteString("; ")
+			}
+			fmt.Fprintf(&b, "#%d after %s: %v", i+1, a.Duration.Round(time.Millisecond), a.Err)
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+func (e *DialError) Unwrap() []error {
+	errs := []error{e.Err}
+	for _, a := range e.Attempts {
+		if a.Err != e.Err {
+			errs = append(errs, a.Err)
+		}
+	}
+	return errs
+}
+
+// Timeout makes DialError a net.Error. It reports whether the Dialer
+// stopped because of a timeout.
+func (e *DialError) Timeout() bool {
+	var t interface{ Timeout() bool }
+	return errors.As(e.Err, &t) && t.Timeout()
+}
+
+func (e *DialError) Temporary() bool { return false }
+
+// DialContext connects to address on network, retrying failed attempts
+// until one succeeds, the attempts run out, an attempt fails with an error
+// that isn't retryable, or ctx is done. If no attempt succeeds, it returns
+// a *DialError.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	attempts := d.Attempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	retryable := d.Retryable
+	if retryable == nil {
+		retryable = retryableDialError
+	}
+
+	backoff := Backoff{Initial: d.Backoff, Max: d.MaxBackoff, Jitter: d.Jitter}
+	if backoff.Initial <= 0 {
+		backoff.Initial = 100 * time.Millisecond
+	}
+
+	dErr := &DialError{Network: network, Address: address}
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			timer := time.NewTimer(backoff.Delay(i))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				dErr.Err = ctx.Err()
+				return nil, dErr
+			case <-timer.C:
+			}
+		}
+
+		start := time.Now()
+		conn, err := d.attempt(ctx, network, address)
+		if err == nil {
+			return conn, nil
+		}
+		dErr.Attempts = append(dErr.Attempts, DialAttempt{Start: start, Duration: time.Since(start), Err: err})
+		dErr.Err = err
+		// 整体的截止时间已到，或者错误不值得重试
+		if ctx.Err() != nil || !retryable(err) {
+			break
+		}
+	}
+	return nil, dErr
+}
+
+func (d *Dialer) attempt(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.AttemptTimeout)
+		defer cancel()
+	}
+	if d.Dial != nil {
+		return d.Dial(ctx, network, address)
+	}
+	var nd net.Dialer
+	return nd.DialContext(ctx, network, address)
+}
+
+func retryableDialError(err error) bool {
+	return temporary(err) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,135 @@
+package ch3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDialerRetries(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	// 前两次拨号失败，模拟服务器正在重启
+	var calls []time.Time
+	d := &Dialer{
+		Backoff: 20 * time.Millisecond,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			calls = append(calls, time.Now())
+			if len(calls) <= 2 {
+				return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+			}
+			var nd net.Dialer
+			return nd.DialContext(ctx, network, address)
+		},
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 attempts; actual %d", len(calls))
+	}
+	// 第二次重试之前的等待时间加倍
+	if gap := calls[1].Sub(calls[0]); gap < 20*time.Millisecond {
+		t.Errorf("expected a 20ms backoff; actual %s", gap)
+	}
+	if gap := calls[2].Sub(calls[1]); gap < 40*time.Millisecond {
+		t.Errorf("expected a 40ms backoff; actual %s", gap)
+	}
+}
+
+func TestDialerAllAttemptsFail(t *testing.T) {
+	// 监听后立刻关闭，得到一个拒绝连接的地址
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	d := &Dialer{Attempts: 4, Backoff: time.Millisecond, Jitter: 0.5}
+	_, err = d.DialContext(context.Background(), "tcp", addr)
+	var dErr *DialError
+	if !errors.As(err, &dErr) {
+		t.Fatalf("expected a DialError; actual %v", err)
+	}
+	if len(dErr.Attempts) != 4 {
+		t.Errorf("expected 4 attempts; actual %d", len(dErr.Attempts))
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("expected the error to wrap ECONNREFUSED: %v", err)
+	}
+	t.Log(err)
+}
+
+func TestDialerAttemptTimeout(t *testing.T) {
+	// 每次拨号都一直阻塞，直到拨号的context被取消
+	attempts := 0
+	d := &Dialer{
+		AttemptTimeout: 50 * time.Millisecond,
+		Backoff:        10 * time.Millisecond,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			attempts++
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+
+	// 整体的截止时间在第三次尝试的中途到达
+	ctx, cancel := context.WithTimeout(context.Background(), 170*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_, err := d.DialContext(ctx, "tcp", "10.0.0.0:80")
+	if time.Since(begin) > time.Second {
+		t.Errorf("dialing took %s", time.Since(begin))
+	}
+
+	var dErr *DialError
+	if !errors.As(err, &dErr) {
+		t.Fatalf("expected a DialError; actual %v", err)
+	}
+	if attempts != 3 || len(dErr.Attempts) != 3 {
+		t.Errorf("expected 3 attempts; actual %d", attempts)
+	}
+	for i, a := range dErr.Attempts[:2] {
+		if a.Duration < 50*time.Millisecond || a.Duration > 100*time.Millisecond {
+			t.Errorf("attempt %d: expected the attempt timeout; took %s", i, a.Duration)
+		}
+	}
+	if !dErr.Timeout() || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a timeout; actual %v", err)
+	}
+}
+
+func TestDialerNotRetryable(t *testing.T) {
+	attempts := 0
+	d := &Dialer{
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			attempts++
+			return nil, fmt.Errorf("lookup example.invalid: %w", errors.New("no such host"))
+		},
+	}
+	_, err := d.DialContext(context.Background(), "tcp", "example.invalid:80")
+	if err == nil || attempts != 1 {
+		t.Errorf("expected a single failed attempt; actual %d attempts, %v", attempts, err)
+	}
+}
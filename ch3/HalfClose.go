@@ -0,0 +1,20 @@
+package ch3
+
+import (
+	"errors"
+	"net"
+)
+
+var ErrHalfCloseUnsupported = errors.New("half-close not supported")
+
+// CloseWrite shuts down the writing side of conn if it supports half-close,
+// as *net.TCPConn and *net.UnixConn do. Otherwise it returns
+// ErrHalfCloseUnsupported and leaves conn open, so the caller can decide
+// whether to close it instead. Connection wrappers use it to implement
+// their own CloseWrite.
+func CloseWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return ErrHalfCloseUnsupported
+}
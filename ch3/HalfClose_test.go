@@ -0,0 +1,44 @@
+package ch3
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestCloseWrite(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	if err = CloseWrite(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected io.EOF after half-close; actual %v", err)
+	}
+	if _, err = server.Write([]byte("still open")); err != nil {
+		t.Errorf("expected the other direction to stay open; actual %v", err)
+	}
+
+	// net.Pipe不支持半关闭，CloseWrite不会替调用者关闭连接
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if err = CloseWrite(a); !errors.Is(err, ErrHalfCloseUnsupported) {
+		t.Errorf("expected ErrHalfCloseUnsupported; actual %v", err)
+	}
+}
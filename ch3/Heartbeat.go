@@ -0,0 +1,316 @@
+package ch3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	pingMsg = []byte("ping")
+	pongMsg = []byte("pong")
+)
+
+// HeartbeatState is what a Heartbeat knows about the peer.
+type HeartbeatState int
+
+const (
+	Alive   HeartbeatState = iota // heard from the peer within the last interval
+	Suspect                       // missed at least one interval
+	Dead                          // missed too many intervals; the connection is closed
+)
+
+func (s HeartbeatState) String() string {
+	switch s {
+	case Alive:
+		return "alive"
+	case Suspect:
+		return "suspect"
+	case Dead:
+		return "dead"
+	default:
+		return fmt.Sprintf("HeartbeatState(%d)", int(s))
+	}
+}
+
+// UnresponsiveError is returned by a HeartbeatConn once the peer has been
+// silent for too many intervals.
+type UnresponsiveError struct {
+	Missed   int       // intervals without a pong or any other data
+	LastSeen time.Time // when the peer last sent something
+}
+
+func (e *UnresponsiveError) Error() string {
+	return fmt.Sprintf("peer unresponsive: missed %d intervals, last seen %s ago",
+		e.Missed, time.Since(e.LastSeen).Round(time.Millisecond))
+}
+
+// Timeout makes UnresponsiveError a net.Error that reports a timeout.
+func (e *UnresponsiveError) Timeout() bool   { return true }
+func (e *UnresponsiveError) Temporary() bool { return false }
+
+// Heartbeat keeps a connection alive and detects a dead peer. It sends
+// "ping" with Pinger whenever the connection has been quiet for Interval,
+// answers the peer's pings with "pong", and counts anything it receives,
+// pongs and normal traffic alike, as a sign of life.
+type Heartbeat struct {
+	Interval  time.Duration // how often to expect a sign of life; defaults to 30s
+	MaxMissed int           // intervals the peer may miss before the connection is closed; defaults to 3
+
+	// OnStateChange, if set, is called when the peer's state changes.
+	OnStateChange func(from, to HeartbeatState)
+}
+
+// Wrap starts the heartbeat on conn. The returned connection must be used
+// in place of conn: it reads from conn in the background, so that a dead
+// peer is noticed even while nobody is reading, and strips the heartbeat
+// messages from what it returns.
+//
+// Messages are recognized only when a read returns nothing but heartbeat
+// messages, so the protocol on conn must not send "ping" or "pong" on
+// their own.
+func (h Heartbeat) Wrap(ctx context.Context, conn net.Conn) *HeartbeatConn {
+	if h.Interval <= 0 {
+		h.Interval = defaultPingInterval
+	}
+	if h.MaxMissed <= 0 {
+		h.MaxMissed = 3
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	c := &HeartbeatConn{
+		Conn:     conn,
+		h:        h,
+		cancel:   cancel,
+		reset:    make(chan time.Duration, 1),
+		chunks:   make(chan []byte, 16),
+		done:     make(chan struct{}),
+		lastSeen: time.Now(),
+		changed:  make(chan struct{}),
+	}
+	c.reset <- h.Interval
+	go Pinger(ctx, pingWriter{c}, c.reset)
+	go c.readLoop(ctx)
+	return c
+}
+
+// HeartbeatConn is a connection with a running Heartbeat.
+type HeartbeatConn struct {
+	net.Conn
+	h      Heartbeat
+	cancel context.CancelFunc
+	reset  chan time.Duration
+
+	writeMu sync.Mutex // serializes pings, pongs and the caller's writes
+
+	chunks  chan []byte   // data from the peer, heartbeat messages removed
+	pending []byte        // the rest of a chunk the caller didn't read
+	done    chan struct{} // closed when readLoop ends; err says why
+	err     error         // written only by readLoop before it closes done
+
+	mu       sync.Mutex
+	state    HeartbeatState
+	lastSeen time.Time
+	deadline time.Time     // the caller's read deadline
+	changed  chan struct{} // closed and replaced when deadline changes
+}
+
+type pingWriter struct{ c *HeartbeatConn }
+
+func (w pingWriter) Write(p []byte) (int, error) {
+	w.c.writeMu.Lock()
+	defer w.c.writeMu.Unlock()
+	return w.c.Conn.Write(p)
+}
+
+func (c *HeartbeatConn) readLoop(ctx context.Context) {
+	defer close(c.done)
+	buf := make([]byte, 32*1024)
+	missed := 0
+	for {
+		// 对端的ping和pong大约每个Interval到达一次，留出半个Interval的余量，
+		// 免得空闲但正常的连接在Alive和Suspect之间来回切换
+		c.mu.Lock()
+		lastSeen := c.lastSeen
+		c.mu.Unlock()
+		slack := c.h.Interval / 2
+		_ = c.Conn.SetReadDeadline(lastSeen.Add(slack + time.Duration(missed+1)*c.h.Interval))
+		n, err := c.Conn.Read(buf)
+		if n > 0 {
+			missed = 0
+			c.seen()
+			if data := c.strip(buf[:n]); len(data) > 0 {
+				select {
+				case c.chunks <- data:
+				case <-ctx.Done():
+					c.err = net.ErrClosed
+					return
+				}
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		var nErr net.Error
+		if errors.As(err, &nErr) && nErr.Timeout() && ctx.Err() == nil {
+			missed++
+			if missed < c.h.MaxMissed {
+				c.setState(Suspect)
+				continue
+			}
+			c.err = &UnresponsiveError{Missed: missed, LastSeen: lastSeen}
+			c.setState(Dead)
+			c.cancel()
+			_ = c.Conn.Close()
+			return
+		}
+		if ctx.Err() != nil {
+			err = net.ErrClosed
+		}
+		c.err = err
+		return
+	}
+}
+
+// strip removes the heartbeat messages from p if it consists of nothing
+// else, answering pings with pongs, and returns a copy of what's left.
+func (c *HeartbeatConn) strip(p []byte) []byte {
+	pings := 0
+	for rest := p; len(rest) > 0; {
+		switch {
+		case bytes.HasPrefix(rest, pingMsg):
+			pings++
+			rest = rest[len(pingMsg):]
+		case bytes.HasPrefix(rest, pongMsg):
+			rest = rest[len(pongMsg):]
+		default:
+			return append([]byte(nil), p...)
+		}
+	}
+	// 多个ping只需要回复一次
+	if pings > 0 {
+		_, _ = pingWriter{c}.Write(pongMsg)
+	}
+	return nil
+}
+
+func (c *HeartbeatConn) seen() {
+	c.mu.Lock()
+	c.lastSeen = time.Now()
+	c.mu.Unlock()
+	c.setState(Alive)
+}
+
+func (c *HeartbeatConn) setState(s HeartbeatState) {
+	c.mu.Lock()
+	from := c.state
+	c.state = s
+	c.mu.Unlock()
+	if from != s && c.h.OnStateChange != nil {
+		c.h.OnStateChange(from, s)
+	}
+}
+
+// State returns what the heartbeat currently knows about the peer.
+func (c *HeartbeatConn) State() HeartbeatState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Read reads data from the peer, without heartbeat messages. Once the peer
+// is found unresponsive, Read returns an *UnresponsiveError.
+func (c *HeartbeatConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		c.mu.Lock()
+		deadline, changed := c.deadline, c.changed
+		c.mu.Unlock()
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		err := c.wait(deadline, changed)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// wait waits for the next chunk from readLoop until deadline, returning
+// early without an error if changed is closed, so Read can pick up a new
+// deadline.
+func (c *HeartbeatConn) wait(deadline time.Time, changed <-chan struct{}) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case c.pending = <-c.chunks:
+	case <-c.done:
+		// readLoop可能在结束之前刚刚送出最后的数据
+		select {
+		case c.pending = <-c.chunks:
+		default:
+			if c.err == nil {
+				return io.EOF
+			}
+			return c.err
+		}
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	case <-changed:
+	}
+	return nil
+}
+
+// Write writes to the connection. Since the peer sees the data as a sign
+// of life, it postpones the next ping.
+func (c *HeartbeatConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	n, err := c.Conn.Write(p)
+	c.writeMu.Unlock()
+	if n > 0 {
+		select {
+		case c.reset <- 0:
+		default:
+		}
+	}
+	return n, err
+}
+
+// SetReadDeadline sets the deadline for the caller's reads, including one
+// already blocked. The heartbeat manages the underlying connection's read
+// deadline itself.
+func (c *HeartbeatConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return nil
+}
+
+func (c *HeartbeatConn) SetDeadline(t time.Time) error {
+	_ = c.SetReadDeadline(t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// Close stops the heartbeat and closes the connection.
+func (c *HeartbeatConn) Close() error {
+	c.cancel()
+	err := c.Conn.Close()
+	<-c.done
+	return err
+}
@@ -0,0 +1,192 @@
+package ch3
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// LimitListener wraps a net.Listener for servers that run an accept loop.
+// It holds off accepting while MaxConns connections are open, retries
+// temporary Accept errors such as running out of file descriptors with
+// exponential backoff instead of returning them, and can drain: stop
+// accepting and wait for the open connections to finish.
+type LimitListener struct {
+	net.Listener
+
+	MaxConns   int           // connections open at once; 0 means no limit
+	Backoff    time.Duration // first delay after a temporary Accept error; defaults to 5ms
+	MaxBackoff time.Duration // longest delay; defaults to 1s
+
+	// ErrorLog, if set, receives the temporary Accept errors.
+	ErrorLog *log.Logger
+
+	once    sync.Once
+	slots   chan struct{} // one per open connection if MaxConns > 0
+	closing chan struct{} // closed when the listener is closed or drains
+	stop    sync.Once
+	drained chan struct{} // closed once draining and no connections are open
+
+	mu       sync.Mutex
+	active   map[*limitConn]struct{}
+	draining bool
+}
+
+func (l *LimitListener) init() {
+	l.once.Do(func() {
+		if l.MaxConns > 0 {
+			l.slots = make(chan struct{}, l.MaxConns)
+		}
+		l.closing = make(chan struct{})
+		l.drained = make(chan struct{})
+		l.active = make(map[*limitConn]struct{})
+	})
+}
+
+// Accept waits until fewer than MaxConns connections are open and returns
+// the next connection. Closing the connection frees its slot.
+func (l *LimitListener) Accept() (net.Conn, error) {
+	l.init()
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-l.closing:
+			return nil, net.ErrClosed
+		}
+	}
+
+	backoff := Backoff{Initial: 5 * time.Millisecond, Max: time.Second}
+	if l.Backoff > 0 {
+		backoff.Initial = l.Backoff
+	}
+	if l.MaxBackoff > 0 {
+		backoff.Max = l.MaxBackoff
+	}
+	for retries := 1; ; retries++ {
+		conn, err := l.Listener.Accept()
+		if err == nil {
+			return l.track(conn)
+		}
+		if !temporary(err) {
+			l.release()
+			return nil, err
+		}
+
+		// 临时错误（比如文件描述符用尽）时等待一段时间再重试，而不是退出
+		delay := backoff.Delay(retries)
+		if l.ErrorLog != nil {
+			l.ErrorLog.Printf("accept: %v; retrying in %s", err, delay)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-l.closing:
+			timer.Stop()
+			l.release()
+			return nil, net.ErrClosed
+		}
+	}
+}
+
+func (l *LimitListener) track(conn net.Conn) (net.Conn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.draining {
+		// Drain关闭监听器之前已经到达的连接
+		_ = conn.Close()
+		l.release()
+		return nil, net.ErrClosed
+	}
+	c := &limitConn{Conn: conn, l: l}
+	l.active[c] = struct{}{}
+	return c, nil
+}
+
+func (l *LimitListener) untrack(c *limitConn) {
+	l.mu.Lock()
+	delete(l.active, c)
+	if l.draining && len(l.active) == 0 {
+		l.closeDrained()
+	}
+	l.mu.Unlock()
+	l.release()
+}
+
+func (l *LimitListener) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// closeDrained closes l.drained once; l.mu must be held.
+func (l *LimitListener) closeDrained() {
+	select {
+	case <-l.drained:
+	default:
+		close(l.drained)
+	}
+}
+
+// Active returns the number of open connections.
+func (l *LimitListener) Active() int {
+	l.init()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.active)
+}
+
+// Close stops accepting. Open connections aren't affected.
+func (l *LimitListener) Close() error {
+	l.init()
+	err := l.Listener.Close()
+	l.stop.Do(func() { close(l.closing) })
+	return err
+}
+
+// Drain stops accepting and waits for the open connections to be closed.
+// If ctx is done first, Drain closes the remaining connections and
+// returns an error wrapping ctx's.
+func (l *LimitListener) Drain(ctx context.Context) error {
+	l.init()
+	l.mu.Lock()
+	l.draining = true
+	if len(l.active) == 0 {
+		l.closeDrained()
+	}
+	l.mu.Unlock()
+	_ = l.Close()
+
+	select {
+	case <-l.drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	conns := make([]*limitConn, 0, len(l.active))
+	for c := range l.active {
+		conns = append(conns, c)
+	}
+	l.mu.Unlock()
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	return fmt.Errorf("draining: closed %d active connections: %w", len(conns), ctx.Err())
+}
+
+type limitConn struct {
+	net.Conn
+	l    *LimitListener
+	once sync.Once
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.l.untrack(c) })
+	return err
+}
+
+func (c *limitConn) CloseWrite() error { return CloseWrite(c.Conn) }
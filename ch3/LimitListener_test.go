@@ -0,0 +1,173 @@
+package ch3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLimitListenerMaxConns(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &LimitListener{Listener: listener, MaxConns: 1}
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+
+	first := <-accepted
+	// 达到上限后第二个连接留在内核的队列里
+	select {
+	case <-accepted:
+		t.Fatal("accepted a connection beyond MaxConns")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := l.Active(); n != 1 {
+		t.Errorf("expected 1 active connection; actual %d", n)
+	}
+
+	_ = first.Close()
+	select {
+	case second := <-accepted:
+		_ = second.Close()
+	case <-time.After(time.Second):
+		t.Fatal("closing a connection did not free its slot")
+	}
+}
+
+// flakyListener fails Accept with a temporary error a few times.
+type flakyListener struct {
+	net.Listener
+	failures int
+	err      error
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, l.err
+	}
+	return l.Listener.Accept()
+}
+
+func TestLimitListenerBackoff(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	emfile := &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	logs := new(bytes.Buffer)
+	l := &LimitListener{
+		Listener: &flakyListener{Listener: listener, failures: 3, err: emfile},
+		Backoff:  10 * time.Millisecond,
+		ErrorLog: log.New(logs, "", 0),
+	}
+	defer l.Close()
+
+	go func() {
+		c, err := net.Dial("tcp", listener.Addr().String())
+		if err == nil {
+			defer c.Close()
+			_, _ = c.Read(make([]byte, 1))
+		}
+	}()
+
+	// 等待10ms、20ms、40ms后重试成功
+	begin := time.Now()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if d := time.Since(begin); d < 70*time.Millisecond {
+		t.Errorf("expected at least 70ms of backoff; actual %s", d)
+	}
+	if n := strings.Count(logs.String(), "too many open files"); n != 3 {
+		t.Errorf("expected 3 logged errors; actual %q", logs)
+	}
+
+	// 其他错误直接返回
+	l.Listener = &flakyListener{Listener: listener, failures: 1, err: io.ErrUnexpectedEOF}
+	if _, err = l.Accept(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected the permanent error; actual %v", err)
+	}
+}
+
+func TestLimitListenerDrain(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &LimitListener{Listener: listener}
+
+	var clients, servers []net.Conn
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		clients = append(clients, c)
+		s, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, s)
+	}
+
+	// 第一个连接很快结束，第二个连接一直不结束
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = servers[0].Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = l.Drain(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the drain to time out; actual %v", err)
+	}
+	if !strings.Contains(err.Error(), "closed 1 active") {
+		t.Errorf("expected one connection to be closed by force: %v", err)
+	}
+	if n := l.Active(); n != 0 {
+		t.Errorf("expected no active connections; actual %d", n)
+	}
+
+	_ = clients[1].SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = clients[1].Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the remaining connection to be closed; actual %v", err)
+	}
+	if _, err = l.Accept(); err == nil {
+		t.Error("expected Accept to fail after draining")
+	}
+	if err = l.Drain(context.Background()); err != nil {
+		t.Errorf("expected draining an idle listener to succeed; actual %v", err)
+	}
+}
@@ -1,39 +1,88 @@
 package ch3
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"io"
+	"sync/atomic"
 	"time"
 )
 
 const defaultPingInterval = 30 * time.Second
 
 func Pinger(ctx context.Context, w io.Writer, reset <-chan time.Duration) {
+	_ = new(PingSender).Run(ctx, w, reset)
+}
+
+// PingEncoder writes the ping with sequence number seq to w. Sequence
+// numbers start at 1 and increase with every ping sent.
+type PingEncoder func(w io.Writer, seq uint64) error
+
+// PingSender is a configurable Pinger. The zero value behaves like Pinger.
+type PingSender struct {
+	// Encode writes each ping. It defaults to writing "ping". The message
+	// is encoded into a buffer first and written to the connection in a
+	// single Write, so an encoder such as a TLV Payload's WriteTo, which
+	// writes the type, length and value separately, can't interleave with
+	// other writers.
+	Encode PingEncoder
+
+	// Jitter spreads pings out so many clients started together don't
+	// ping in lockstep: every wait is randomly lengthened or shortened by
+	// up to this fraction of the interval. 0.1 means ±10%.
+	Jitter float64
+
+	// Backoff is the delay before retrying a ping after a temporary write
+	// error; it doubles with every failure up to MaxBackoff. If Backoff is
+	// 0, the first write error stops the PingSender, like Pinger.
+	Backoff    time.Duration
+	MaxBackoff time.Duration // defaults to 32 times Backoff
+	MaxRetries int           // consecutive retries before giving up; 0 means no limit
+
+	sent        atomic.Uint64
+	writeErrors atomic.Uint64
+}
+
+// Sent returns the number of pings written.
+func (p *PingSender) Sent() uint64 { return p.sent.Load() }
+
+// WriteErrors returns the number of failed writes, retried or not.
+func (p *PingSender) WriteErrors() uint64 { return p.writeErrors.Load() }
+
+// Run writes pings to w until ctx is canceled or a write fails for good,
+// and returns the write error, or nil if ctx was canceled. It reads the
+// interval from reset the same way Pinger does.
+func (p *PingSender) Run(ctx context.Context, w io.Writer, reset <-chan time.Duration) error {
 	var interval time.Duration
 	// 从reset通道中拿到一个时间间隔
 	select {
 	case <-ctx.Done():
-		return
+		return nil
 	case interval = <-reset:
 	default:
 	}
 	if interval <= 0 {
 		interval = defaultPingInterval
 	}
+	backoff := Backoff{Initial: p.Backoff, Max: p.MaxBackoff}
+
 	// 创建一个定时器
-	timer := time.NewTimer(interval)
-	// 函数结执行时清空`C`通道
-	defer func() {
-		if !timer.Stop() {
-			<-timer.C
-		}
-	}()
+	timer := time.NewTimer(jitter(interval, p.Jitter))
+	// 函数结束时停止定时器。写入失败时定时器已经触发过，不能再等待`C`通道
+	defer timer.Stop()
 
+	var (
+		buf     bytes.Buffer
+		seq     uint64
+		retries int
+	)
 	// 无线循环，等待新的时间间隔或者写出数据
 	for {
+		next := interval
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		case newInterval := <-reset:
 			if !timer.Stop() {
 				<-timer.C
@@ -41,12 +90,49 @@ func Pinger(ctx context.Context, w io.Writer, reset <-chan time.Duration) {
 			if newInterval > 0 {
 				interval = newInterval
 			}
+			next = interval
 		case <-timer.C:
-			if _, err := w.Write([]byte("ping")); err != nil {
-				return
+			if retries == 0 {
+				seq++
+				buf.Reset()
+				if err := p.encode(&buf, seq); err != nil {
+					return err
+				}
+			}
+			n, err := w.Write(buf.Bytes())
+			if err == nil {
+				p.sent.Add(1)
+				retries = 0
+				break
 			}
+			p.writeErrors.Add(1)
+			// 部分写入会破坏消息的边界，不能重试
+			if n > 0 || p.Backoff <= 0 || !temporary(err) ||
+				(p.MaxRetries > 0 && retries >= p.MaxRetries) {
+				return err
+			}
+			retries++
+			next = backoff.Delay(retries)
 		}
-		_ = timer.Reset(interval)
+		_ = timer.Reset(jitter(next, p.Jitter))
 	}
+}
 
+func (p *PingSender) encode(w io.Writer, seq uint64) error {
+	if p.Encode == nil {
+		_, err := w.Write([]byte("ping"))
+		return err
+	}
+	return p.Encode(w, seq)
+}
+
+// temporary reports whether err is worth retrying: a timeout, or an error
+// that says it's temporary.
+func temporary(err error) bool {
+	var t interface{ Timeout() bool }
+	if errors.As(err, &t) && t.Timeout() {
+		return true
+	}
+	var tmp interface{ Temporary() bool }
+	return errors.As(err, &tmp) && tmp.Temporary()
 }
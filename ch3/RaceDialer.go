@@ -0,0 +1,188 @@
+package ch3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ErrLostRace is the outcome of a dial that connected after another
+// address had already won. Its connection is closed.
+var ErrLostRace = errors.New("connected after another address won")
+
+// DialOutcome describes the dial to one address of a race.
+type DialOutcome struct {
+	Address  string
+	Started  bool          // false if the race ended before its turn
+	Delay    time.Duration // from the start of the race to the start of this dial
+	Duration time.Duration // how long this dial took
+	Winner   bool
+	Err      error // nil for the winner and for addresses never dialed
+}
+
+// RaceDialer dials several addresses of the same service and keeps the
+// first connection that succeeds, like the dialers in
+// TestDialContextCancelFanOut, but starting them one after another so a
+// healthy first address doesn't cost a connection to every address.
+type RaceDialer struct {
+	// Stagger is how long to wait for a dial before starting the next one
+	// in parallel. A dial that fails starts the next one right away. It
+	// defaults to 300ms.
+	Stagger time.Duration
+
+	// Dial connects to one address. It defaults to net.Dialer's
+	// DialContext; a *Dialer's DialContext adds retries.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// Resolver looks up the addresses of a host for DialHost. It defaults
+	// to net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+type raceResult struct {
+	i    int
+	conn net.Conn
+	err  error
+}
+
+// DialContext races dials to addresses in order and returns the first
+// connection made, along with the outcome of every address. As soon as one
+// dial succeeds, the others are canceled. DialContext waits for all of
+// them to return, closing any that connected anyway, so no connection
+// leaks once it returns.
+func (r *RaceDialer) DialContext(ctx context.Context, network string,
+	addresses []string) (net.Conn, []DialOutcome, error) {
+	outcomes := make([]DialOutcome, len(addresses))
+	for i, a := range addresses {
+		outcomes[i].Address = a
+	}
+	if len(addresses) == 0 {
+		return nil, outcomes, fmt.Errorf("dial %s: no addresses", network)
+	}
+	stagger := r.Stagger
+	if stagger <= 0 {
+		stagger = 300 * time.Millisecond
+	}
+	dial := r.Dial
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	begin := time.Now()
+	results := make(chan raceResult, len(addresses))
+	started, pending := 0, 0
+	var next <-chan time.Time
+	start := func() {
+		i := started
+		started++
+		pending++
+		outcomes[i] = DialOutcome{Address: addresses[i], Started: true, Delay: time.Since(begin)}
+		go func() {
+			conn, err := dial(ctx, network, addresses[i])
+			results <- raceResult{i: i, conn: conn, err: err}
+		}()
+		if started < len(addresses) {
+			next = time.After(stagger)
+		} else {
+			next = nil
+		}
+	}
+	var winner net.Conn
+	start()
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			o := &outcomes[res.i]
+			o.Duration = time.Since(begin) - o.Delay
+			switch {
+			case res.err != nil:
+				o.Err = res.err
+				// 拨号失败时不必等待，立即开始下一个地址
+				if winner == nil && started < len(addresses) && ctx.Err() == nil {
+					start()
+				}
+			case winner == nil:
+				winner = res.conn
+				o.Winner = true
+				cancel() // 取消其他正在进行的拨号
+			default:
+				// 赢家已经产生，关闭迟到的连接
+				_ = res.conn.Close()
+				o.Err = ErrLostRace
+			}
+		case <-next:
+			if winner == nil && ctx.Err() == nil {
+				start()
+			}
+		}
+	}
+
+	if winner != nil {
+		return winner, outcomes, nil
+	}
+	errs := make([]error, 0, started)
+	for _, o := range outcomes[:started] {
+		errs = append(errs, o.Err)
+	}
+	return nil, outcomes, fmt.Errorf("dial %s: %d of %d addresses failed: %w",
+		network, started, len(addresses), errors.Join(errs...))
+}
+
+// DialHost races dials to every IP address of the host in address, a
+// "host:port" string, alternating between IPv6 and IPv4 addresses in the
+// order the resolver returned them.
+func (r *RaceDialer) DialHost(ctx context.Context, network, address string) (net.Conn, []DialOutcome, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return r.DialContext(ctx, network, []string{address})
+	}
+
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var v4, v6 []string
+	for _, ip := range ips {
+		switch {
+		case ip.IP.To4() != nil:
+			if network != "tcp6" {
+				v4 = append(v4, net.JoinHostPort(ip.String(), port))
+			}
+		case network != "tcp4":
+			v6 = append(v6, net.JoinHostPort(ip.String(), port))
+		}
+	}
+	// 按照Happy Eyeballs的做法交替使用两种地址，首选解析结果中的第一种
+	first, second := v6, v4
+	if len(ips) > 0 && ips[0].IP.To4() != nil {
+		first, second = v4, v6
+	}
+	addresses := make([]string, 0, len(v4)+len(v6))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			addresses = append(addresses, first[i])
+		}
+		if i < len(second) {
+			addresses = append(addresses, second[i])
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, nil, fmt.Errorf("dial %s %s: no suitable address", network, address)
+	}
+	return r.DialContext(ctx, network, addresses)
+}
@@ -0,0 +1,158 @@
+package ch3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRaceDialerStaggersAndCancels(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}()
+
+	// 第一个地址没有应答，直到拨号被取消
+	r := &RaceDialer{
+		Stagger: 50 * time.Millisecond,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if address == "blackhole" {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+	}
+	conn, outcomes, err := r.DialContext(context.Background(), "tcp",
+		[]string{"blackhole", listener.Addr().String(), "never"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	if !errors.Is(outcomes[0].Err, context.Canceled) {
+		t.Errorf("expected the first dial to be canceled; actual %v", outcomes[0].Err)
+	}
+	if !outcomes[1].Winner || outcomes[1].Delay < 50*time.Millisecond {
+		t.Errorf("expected the second address to win after the stagger; actual %+v", outcomes[1])
+	}
+	if outcomes[2].Started {
+		t.Errorf("expected the third address not to be dialed; actual %+v", outcomes[2])
+	}
+}
+
+func TestRaceDialerClosesLateWinners(t *testing.T) {
+	// 第一个地址忽略取消，在赢家之后才建立连接
+	var mu sync.Mutex
+	var late net.Conn
+	r := &RaceDialer{
+		Stagger: 20 * time.Millisecond,
+		Dial: func(ctx context.Context, _, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			if address == "slow" {
+				time.Sleep(100 * time.Millisecond)
+				mu.Lock()
+				late = server
+				mu.Unlock()
+			}
+			return client, nil
+		},
+	}
+	conn, outcomes, err := r.DialContext(context.Background(), "tcp", []string{"slow", "fast"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if !outcomes[1].Winner || !errors.Is(outcomes[0].Err, ErrLostRace) {
+		t.Fatalf("expected the late connection to lose; actual %+v", outcomes)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	_, err = late.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Errorf("expected the late connection to be closed; actual %v", err)
+	}
+}
+
+func TestRaceDialerFailureStartsNext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}()
+	closed, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := closed.Addr().String()
+	_ = closed.Close()
+
+	// 第一个地址拒绝连接，不必等待间隔时间
+	r := &RaceDialer{Stagger: 5 * time.Second}
+	begin := time.Now()
+	conn, outcomes, err := r.DialContext(context.Background(), "tcp",
+		[]string{refused, listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if time.Since(begin) > time.Second {
+		t.Errorf("expected the second dial to start right away; took %s", time.Since(begin))
+	}
+	if !errors.Is(outcomes[0].Err, syscall.ECONNREFUSED) || !outcomes[1].Winner {
+		t.Errorf("unexpected outcomes %+v", outcomes)
+	}
+
+	_, outcomes, err = r.DialContext(context.Background(), "tcp", []string{refused, refused})
+	if err == nil {
+		t.Fatal("expected every dial to fail")
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) || outcomes[1].Err == nil {
+		t.Errorf("expected both refusals to be reported; actual %v", err)
+	}
+}
+
+func TestRaceDialerDialHost(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// localhost可能同时解析到::1，它会拒绝连接
+	conn, outcomes, err := new(RaceDialer).DialHost(context.Background(), "tcp", "localhost:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	for _, o := range outcomes {
+		t.Logf("%+v", o)
+	}
+}
@@ -0,0 +1,241 @@
+package ch3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Handler serves one connection. ServeConn should return once ctx is
+// canceled; the Server closes the connection after ServeConn returns.
+type Handler interface {
+	ServeConn(ctx context.Context, conn net.Conn)
+}
+
+// HandlerFunc lets an ordinary function serve connections.
+type HandlerFunc func(ctx context.Context, conn net.Conn)
+
+func (f HandlerFunc) ServeConn(ctx context.Context, conn net.Conn) { f(ctx, conn) }
+
+// ConnState is reported to Server.ConnState as a connection goes through
+// the server.
+type ConnState int
+
+const (
+	StateNew    ConnState = iota // accepted
+	StateActive                  // handed to the Handler
+	StateClosed                  // the Handler returned and the connection is closed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateActive:
+		return "active"
+	case StateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("ConnState(%d)", int(s))
+	}
+}
+
+// Server runs the accept loop and the goroutine per connection that every
+// TCP server needs, so a server only has to provide a Handler.
+type Server struct {
+	Handler Handler
+
+	// IdleTimeout closes connections with no reads or writes for this
+	// long. ReadTimeout and WriteTimeout limit each Read and Write. Zero
+	// means no limit. They replace any deadlines the Handler sets.
+	IdleTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// MaxConns limits the connections served at once; 0 means no limit.
+	MaxConns int
+
+	// SockOpts are set on the listener ListenAndServe binds and on the
+	// connections it accepts. Serve leaves the listener it's given alone.
+	SockOpts SockOpts
+
+	// ShutdownGrace is how long handlers have to return after the
+	// context passed to Serve is canceled before their connections are
+	// closed. It defaults to 0: connections are closed at once.
+	ShutdownGrace time.Duration
+
+	// ConnState, if set, is called when a connection changes state.
+	ConnState func(net.Conn, ConnState)
+
+	// ErrorLog, if set, receives accept errors and handler panics.
+	ErrorLog *log.Logger
+}
+
+// ListenAndServe listens on addr and serves connections until ctx is
+// canceled.
+func (s *Server) ListenAndServe(ctx context.Context, network, addr string) error {
+	l, err := s.SockOpts.Listen(ctx, network, addr)
+	if err != nil {
+		return fmt.Errorf("binding to %s %s: %w", network, addr, err)
+	}
+	return s.Serve(ctx, l)
+}
+
+// Serve accepts connections on l and serves each in its own goroutine
+// until ctx is canceled or Accept fails. Temporary Accept errors are
+// retried with backoff. When ctx is canceled, Serve closes l and cancels
+// the context passed to the handlers, waits up to ShutdownGrace for them
+// to return, then closes their connections. It returns once every handler
+// has returned.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	if s.Handler == nil {
+		_ = l.Close()
+		return errors.New("handler is required")
+	}
+	ll := &LimitListener{Listener: l, MaxConns: s.MaxConns, ErrorLog: s.ErrorLog}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		graceCtx, cancelGrace := context.WithTimeout(context.Background(), s.ShutdownGrace)
+		defer cancelGrace()
+		_ = ll.Drain(graceCtx)
+	}()
+
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		<-drained
+		wg.Wait()
+	}()
+	for {
+		conn, err := ll.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		s.setState(conn, StateNew)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer func() {
+		// 一个连接的处理程序崩溃不应该让整个服务器退出
+		if r := recover(); r != nil {
+			s.logf("panic serving %s: %v\n%s", conn.RemoteAddr(), r, debug.Stack())
+		}
+		_ = conn.Close()
+		s.setState(conn, StateClosed)
+	}()
+
+	s.setState(conn, StateActive)
+	c := conn
+	if s.IdleTimeout > 0 || s.ReadTimeout > 0 || s.WriteTimeout > 0 {
+		c = newTimeoutConn(conn, s.IdleTimeout, s.ReadTimeout, s.WriteTimeout)
+	}
+	s.Handler.ServeConn(ctx, c)
+}
+
+func (s *Server) setState(conn net.Conn, state ConnState) {
+	if s.ConnState != nil {
+		s.ConnState(conn, state)
+	}
+}
+
+func (s *Server) logf(format string, v ...any) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, v...)
+	}
+}
+
+// timeoutConn applies the Server's timeouts to every Read and Write.
+type timeoutConn struct {
+	net.Conn
+	idle, read, write time.Duration
+	last              atomic.Int64 // unix nanoseconds of the last read or write
+}
+
+func newTimeoutConn(conn net.Conn, idle, read, write time.Duration) *timeoutConn {
+	c := &timeoutConn{Conn: conn, idle: idle, read: read, write: write}
+	c.last.Store(time.Now().UnixNano())
+	return c
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	start := time.Now()
+	for {
+		_ = c.Conn.SetReadDeadline(c.deadline(start, c.read))
+		n, err := c.Conn.Read(p)
+		if n > 0 {
+			c.last.Store(time.Now().UnixNano())
+		}
+		if err != nil && n == 0 && c.busy(err, start, c.read) {
+			continue // 连接在另一个方向上有数据，还不算空闲
+		}
+		return n, err
+	}
+}
+
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	start := time.Now()
+	var n int
+	for {
+		if c.write > 0 || c.idle > 0 {
+			_ = c.Conn.SetWriteDeadline(c.deadline(start, c.write))
+		}
+		o, err := c.Conn.Write(p[n:])
+		n += o
+		if o > 0 {
+			c.last.Store(time.Now().UnixNano())
+		}
+		if err != nil && c.busy(err, start, c.write) {
+			continue
+		}
+		return n, err
+	}
+}
+
+// deadline returns the deadline for a read or write that started at start
+// and may take up to limit: the earlier of start+limit and the time the
+// connection becomes idle.
+func (c *timeoutConn) deadline(start time.Time, limit time.Duration) time.Time {
+	var deadline time.Time
+	if limit > 0 {
+		deadline = start.Add(limit)
+	}
+	if c.idle > 0 {
+		idle := time.Unix(0, c.last.Load()).Add(c.idle)
+		if deadline.IsZero() || idle.Before(deadline) {
+			deadline = idle
+		}
+	}
+	return deadline
+}
+
+// busy reports whether err is an idle timeout that came too early because
+// the connection saw traffic in the other direction meanwhile.
+func (c *timeoutConn) busy(err error, start time.Time, limit time.Duration) bool {
+	var nErr net.Error
+	return errors.As(err, &nErr) && nErr.Timeout() && c.idle > 0 &&
+		time.Since(time.Unix(0, c.last.Load())) < c.idle &&
+		(limit <= 0 || time.Since(start) < limit)
+}
+
+func (c *timeoutConn) CloseWrite() error { return CloseWrite(c.Conn) }
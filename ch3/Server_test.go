@@ -0,0 +1,208 @@
+package ch3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer runs s until the test ends. The returned channel is closed
+// once Serve returns, after storing its error where the returned pointer
+// points.
+func startServer(t *testing.T, s *Server) (string, context.CancelFunc, <-chan struct{}, *error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var serveErr error
+	go func() {
+		defer close(done)
+		serveErr = s.Serve(ctx, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return listener.Addr().String(), cancel, done, &serveErr
+}
+
+func TestServerShutdownCancelsHandlers(t *testing.T) {
+	var mu sync.Mutex
+	var states []ConnState
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	s := &Server{
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			close(started)
+			<-ctx.Done()
+			close(canceled)
+		}),
+		ConnState: func(_ net.Conn, state ConnState) {
+			mu.Lock()
+			states = append(states, state)
+			mu.Unlock()
+		},
+	}
+	addr, cancel, done, serveErr := startServer(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-started
+
+	cancel()
+	select {
+	case <-done:
+		if *serveErr != nil {
+			t.Fatal(*serveErr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return")
+	}
+	select {
+	case <-canceled:
+	default:
+		t.Error("expected the handler's context to be canceled")
+	}
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the connection to be closed; actual %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) != 3 || states[0] != StateNew || states[1] != StateActive || states[2] != StateClosed {
+		t.Errorf("unexpected states %v", states)
+	}
+}
+
+func TestServerRecoversPanics(t *testing.T) {
+	logs := new(syncBuf)
+	s := &Server{
+		Handler: HandlerFunc(func(_ context.Context, conn net.Conn) {
+			buf := make([]byte, 5)
+			_, _ = io.ReadFull(conn, buf)
+			if string(buf) == "panic" {
+				panic("handler failed")
+			}
+			_, _ = conn.Write(buf)
+		}),
+		ErrorLog: log.New(logs, "", 0),
+	}
+	addr, _, _, _ := startServer(t, s)
+
+	for _, msg := range []string{"panic", "hello"} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = conn.Write([]byte(msg))
+		b, _ := io.ReadAll(conn)
+		_ = conn.Close()
+		// 崩溃的连接被关闭，服务器继续服务其他连接
+		if msg == "hello" && string(b) != "hello" {
+			t.Errorf("expected %q; actual %q", msg, b)
+		}
+	}
+	if !strings.Contains(logs.String(), "panic serving") {
+		t.Errorf("expected the panic to be logged; actual %q", logs)
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	s := &Server{
+		IdleTimeout: 100 * time.Millisecond,
+		Handler: HandlerFunc(func(_ context.Context, conn net.Conn) {
+			_, _ = io.Copy(conn, conn)
+		}),
+	}
+	addr, _, _, _ := startServer(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 持续有数据时连接不会被关闭
+	buf := make([]byte, 4)
+	for i := 0; i < 4; i++ {
+		time.Sleep(60 * time.Millisecond)
+		_, _ = conn.Write([]byte("ping"))
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+	}
+
+	begin := time.Now()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(buf); err != io.EOF {
+		t.Fatalf("expected the idle connection to be closed; actual %v", err)
+	}
+	if d := time.Since(begin); d < 80*time.Millisecond {
+		t.Errorf("connection closed after %s", d)
+	}
+}
+
+func TestServerIdleTimeoutBlockedWrite(t *testing.T) {
+	errc := make(chan error, 1)
+	s := &Server{
+		IdleTimeout: 100 * time.Millisecond,
+		Handler: HandlerFunc(func(_ context.Context, conn net.Conn) {
+			buf := make([]byte, 64<<10)
+			for {
+				if _, err := conn.Write(buf); err != nil {
+					errc <- err
+					return
+				}
+			}
+		}),
+	}
+	addr, _, _, _ := startServer(t, s)
+
+	// 客户端从不读取，服务器的写操作最终会阻塞，空闲超时应当让它失败
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case err = <-errc:
+		var nErr net.Error
+		if !errors.As(err, &nErr) || !nErr.Timeout() {
+			t.Errorf("expected a timeout; actual %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked Write ignored the idle timeout")
+	}
+}
+
+// syncBuf is a bytes.Buffer safe to share with the server's goroutines.
+type syncBuf struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (s *syncBuf) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuf) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
@@ -0,0 +1,166 @@
+package ch3
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SockOpts are socket options to set on listeners and connections instead
+// of the defaults net.Listen and net.Dial use. The zero value changes
+// nothing. They are only supported on Linux; elsewhere, setting any of
+// them makes binding and dialing fail.
+//
+// Use Listen, ListenPacket and DialContext, or pass Control to a
+// net.ListenConfig or net.Dialer of your own. Go sets TCP_NODELAY and
+// keepalive itself once a connection is established, so in the latter
+// case call Apply on every connection too.
+type SockOpts struct {
+	// Setting any of the keepalive fields turns on TCP keepalive:
+	// KeepAliveIdle is the idle time before the first probe,
+	// KeepAliveInterval the time between probes and KeepAliveCount the
+	// probes that go unanswered before the connection is dropped. Unset
+	// fields keep the system defaults. Times are rounded up to seconds.
+	KeepAliveIdle     time.Duration
+	KeepAliveInterval time.Duration
+	KeepAliveCount    int
+
+	// Nagle turns Nagle's algorithm back on by clearing TCP_NODELAY,
+	// which Go sets on every TCP connection.
+	Nagle bool
+
+	// RecvBuffer and SendBuffer set SO_RCVBUF and SO_SNDBUF in bytes.
+	// Linux doubles the value to leave room for its bookkeeping.
+	RecvBuffer int
+	SendBuffer int
+
+	// Linger sets SO_LINGER: Close blocks for up to Linger, rounded up to
+	// seconds, while unsent data is delivered. A negative Linger makes
+	// Close discard unsent data and reset the connection. Zero keeps the
+	// default of closing in the background.
+	Linger time.Duration
+
+	// ReusePort sets SO_REUSEPORT so several sockets can bind the same
+	// address, with the kernel spreading connections or datagrams over
+	// them.
+	ReusePort bool
+
+	// UserTimeout sets TCP_USER_TIMEOUT: the connection is dropped when
+	// sent data stays unacknowledged this long.
+	UserTimeout time.Duration
+}
+
+func (o SockOpts) keepAlive() bool {
+	return o.KeepAliveIdle > 0 || o.KeepAliveInterval > 0 || o.KeepAliveCount > 0
+}
+
+// Control sets the options on a new socket. It has the signature of
+// net.ListenConfig's and net.Dialer's Control field.
+func (o SockOpts) Control(network, _ string, c syscall.RawConn) error {
+	if o == (SockOpts{}) {
+		return nil
+	}
+	var err error
+	cErr := c.Control(func(fd uintptr) {
+		err = o.set(fd, strings.HasPrefix(network, "tcp"))
+	})
+	if cErr != nil {
+		return cErr
+	}
+	return err
+}
+
+// Apply sets the options on an established connection.
+func (o SockOpts) Apply(conn net.Conn) error {
+	if o == (SockOpts{}) {
+		return nil
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("setting socket options: %T has no socket", conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return o.Control(conn.LocalAddr().Network(), conn.LocalAddr().String(), raw)
+}
+
+// ListenConfig returns a net.ListenConfig that sets the options on its
+// sockets. Sockets accepted from a TCP listener inherit the options.
+func (o SockOpts) ListenConfig() *net.ListenConfig {
+	lc := &net.ListenConfig{Control: o.Control}
+	if o.keepAlive() {
+		lc.KeepAlive = -1 // 由Control设置，不要被Go的默认值覆盖
+	}
+	return lc
+}
+
+// Dialer returns a net.Dialer that sets the options on its sockets.
+func (o SockOpts) Dialer() *net.Dialer {
+	d := &net.Dialer{Control: o.Control}
+	if o.keepAlive() {
+		d.KeepAlive = -1
+	}
+	return d
+}
+
+// Listen listens on address with the options set on the listener and on
+// every connection it accepts.
+func (o SockOpts) Listen(ctx context.Context, network, address string) (net.Listener, error) {
+	l, err := o.ListenConfig().Listen(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if !o.Nagle {
+		return l, nil
+	}
+	return &sockOptsListener{Listener: l, opts: o}, nil
+}
+
+// ListenPacket listens on address with the options set on the socket.
+// Only RecvBuffer, SendBuffer and ReusePort apply to packet sockets.
+func (o SockOpts) ListenPacket(ctx context.Context, network, address string) (net.PacketConn, error) {
+	return o.ListenConfig().ListenPacket(ctx, network, address)
+}
+
+// DialContext connects to address with the options set on the socket.
+func (o SockOpts) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := o.Dialer().DialContext(ctx, network, address)
+	if err != nil || !o.Nagle {
+		return conn, err
+	}
+	if err = o.Apply(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sockOptsListener sets the options again on accepted connections, since
+// Go turns TCP_NODELAY back on after accepting.
+type sockOptsListener struct {
+	net.Listener
+	opts SockOpts
+}
+
+func (l *sockOptsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if err = l.opts.Apply(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// seconds rounds d up to whole seconds, for options the kernel takes in
+// seconds.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
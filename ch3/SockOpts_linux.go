@@ -0,0 +1,71 @@
+//go:build !mips && !mipsle && !mips64 && !mips64le
+
+package ch3
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// syscall doesn't define these on every architecture. MIPS uses other
+// values and is left to SockOpts_other.go.
+const (
+	soReusePort    = 0xf
+	tcpUserTimeout = 0x12
+)
+
+func (o SockOpts) set(fd uintptr, tcp bool) error {
+	s := int(fd)
+	var errs []error
+	setInt := func(level, opt, value int, name string) {
+		err := syscall.SetsockoptInt(s, level, opt, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("setting %s: %w", name, os.NewSyscallError("setsockopt", err)))
+		}
+	}
+
+	if o.ReusePort {
+		setInt(syscall.SOL_SOCKET, soReusePort, 1, "SO_REUSEPORT")
+	}
+	if o.RecvBuffer > 0 {
+		setInt(syscall.SOL_SOCKET, syscall.SO_RCVBUF, o.RecvBuffer, "SO_RCVBUF")
+	}
+	if o.SendBuffer > 0 {
+		setInt(syscall.SOL_SOCKET, syscall.SO_SNDBUF, o.SendBuffer, "SO_SNDBUF")
+	}
+	if !tcp {
+		return errors.Join(errs...)
+	}
+
+	if o.keepAlive() {
+		setInt(syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1, "SO_KEEPALIVE")
+		if o.KeepAliveIdle > 0 {
+			setInt(syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, seconds(o.KeepAliveIdle), "TCP_KEEPIDLE")
+		}
+		if o.KeepAliveInterval > 0 {
+			setInt(syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, seconds(o.KeepAliveInterval), "TCP_KEEPINTVL")
+		}
+		if o.KeepAliveCount > 0 {
+			setInt(syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, o.KeepAliveCount, "TCP_KEEPCNT")
+		}
+	}
+	if o.Nagle {
+		setInt(syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 0, "TCP_NODELAY")
+	}
+	if o.Linger != 0 {
+		l := &syscall.Linger{Onoff: 1}
+		if o.Linger > 0 {
+			l.Linger = int32(seconds(o.Linger))
+		}
+		err := syscall.SetsockoptLinger(s, syscall.SOL_SOCKET, syscall.SO_LINGER, l)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("setting SO_LINGER: %w", os.NewSyscallError("setsockopt", err)))
+		}
+	}
+	if o.UserTimeout > 0 {
+		setInt(syscall.IPPROTO_TCP, tcpUserTimeout, int(o.UserTimeout.Milliseconds()), "TCP_USER_TIMEOUT")
+	}
+	return errors.Join(errs...)
+}
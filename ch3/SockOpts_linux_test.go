@@ -0,0 +1,174 @@
+//go:build !mips && !mipsle && !mips64 && !mips64le
+
+package ch3
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// sockopts reads socket options of conn back with getsockopt.
+type sockopts struct {
+	t  *testing.T
+	fd int
+}
+
+func (s sockopts) int(level, opt int) int {
+	s.t.Helper()
+	v, err := syscall.GetsockoptInt(s.fd, level, opt)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return v
+}
+
+// syscall has no GetsockoptLinger.
+func (s sockopts) linger() syscall.Linger {
+	s.t.Helper()
+	var l syscall.Linger
+	size := uint32(unsafe.Sizeof(l))
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(s.fd), syscall.SOL_SOCKET,
+		syscall.SO_LINGER, uintptr(unsafe.Pointer(&l)), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		s.t.Fatal(errno)
+	}
+	return l
+}
+
+func withSockopts(t *testing.T, conn any, check func(sockopts)) {
+	t.Helper()
+	raw, err := conn.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = raw.Control(func(fd uintptr) { check(sockopts{t: t, fd: int(fd)}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSockOptsTCP(t *testing.T) {
+	opts := SockOpts{
+		KeepAliveIdle:     5 * time.Second,
+		KeepAliveInterval: 1500 * time.Millisecond, // 向上取整为2秒
+		KeepAliveCount:    3,
+		Nagle:             true,
+		RecvBuffer:        64 << 10,
+		SendBuffer:        32 << 10,
+		Linger:            -1,
+		ReusePort:         true,
+		UserTimeout:       10 * time.Second,
+	}
+	ctx := context.Background()
+	listener, err := opts.Listen(ctx, "tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// SO_REUSEPORT允许另一个设置了它的套接字绑定相同的地址
+	second, err := opts.Listen(ctx, "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("binding a second socket with SO_REUSEPORT: %v", err)
+	}
+	_ = second.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	client, err := opts.DialContext(ctx, "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server := <-accepted
+	if server == nil {
+		t.FailNow()
+	}
+	defer server.Close()
+
+	for name, conn := range map[string]net.Conn{"dialed": client, "accepted": server} {
+		withSockopts(t, conn, func(s sockopts) {
+			const tcp, sol = syscall.IPPROTO_TCP, syscall.SOL_SOCKET
+			checks := []struct {
+				name             string
+				actual, expected int
+			}{
+				{"SO_KEEPALIVE", s.int(sol, syscall.SO_KEEPALIVE), 1},
+				{"TCP_KEEPIDLE", s.int(tcp, syscall.TCP_KEEPIDLE), 5},
+				{"TCP_KEEPINTVL", s.int(tcp, syscall.TCP_KEEPINTVL), 2},
+				{"TCP_KEEPCNT", s.int(tcp, syscall.TCP_KEEPCNT), 3},
+				{"TCP_NODELAY", s.int(tcp, syscall.TCP_NODELAY), 0},
+				{"SO_REUSEPORT", s.int(sol, soReusePort), 1},
+				{"TCP_USER_TIMEOUT", s.int(tcp, tcpUserTimeout), 10000},
+				// 内核把缓冲区大小加倍
+				{"SO_RCVBUF", s.int(sol, syscall.SO_RCVBUF), 2 * opts.RecvBuffer},
+				{"SO_SNDBUF", s.int(sol, syscall.SO_SNDBUF), 2 * opts.SendBuffer},
+			}
+			for _, c := range checks {
+				if c.actual != c.expected {
+					t.Errorf("%s: expected %s %d; actual %d", name, c.name, c.expected, c.actual)
+				}
+			}
+			if l := s.linger(); l.Onoff != 1 || l.Linger != 0 {
+				t.Errorf("%s: expected SO_LINGER on with 0s; actual %+v", name, l)
+			}
+		})
+	}
+}
+
+func TestSockOptsDefaults(t *testing.T) {
+	// 未设置的选项保持Go的默认值
+	ctx := context.Background()
+	opts := SockOpts{UserTimeout: time.Second}
+	listener, err := opts.Listen(ctx, "tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}()
+	conn, err := opts.DialContext(ctx, "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	withSockopts(t, conn, func(s sockopts) {
+		if v := s.int(syscall.IPPROTO_TCP, syscall.TCP_NODELAY); v != 1 {
+			t.Errorf("expected TCP_NODELAY 1; actual %d", v)
+		}
+		if v := s.int(syscall.IPPROTO_TCP, tcpUserTimeout); v != 1000 {
+			t.Errorf("expected TCP_USER_TIMEOUT 1000; actual %d", v)
+		}
+	})
+}
+
+func TestSockOptsUDP(t *testing.T) {
+	opts := SockOpts{RecvBuffer: 128 << 10, ReusePort: true}
+	pc, err := opts.ListenPacket(context.Background(), "udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	withSockopts(t, pc, func(s sockopts) {
+		if v := s.int(syscall.SOL_SOCKET, syscall.SO_RCVBUF); v != 2*opts.RecvBuffer {
+			t.Errorf("expected SO_RCVBUF %d; actual %d", 2*opts.RecvBuffer, v)
+		}
+		if v := s.int(syscall.SOL_SOCKET, soReusePort); v != 1 {
+			t.Errorf("expected SO_REUSEPORT 1; actual %d", v)
+		}
+	})
+}
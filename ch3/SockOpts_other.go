@@ -0,0 +1,11 @@
+//go:build !linux || mips || mipsle || mips64 || mips64le
+
+package ch3
+
+import "errors"
+
+var errSockOptsUnsupported = errors.New("socket options are only supported on Linux")
+
+func (o SockOpts) set(uintptr, bool) error {
+	return errSockOptsUnsupported
+}
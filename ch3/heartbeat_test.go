@@ -0,0 +1,206 @@
+package ch3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Log(err)
+		}
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		t.FailNow()
+	}
+	return client, server
+}
+
+func TestHeartbeatKeepsIdlePeersAlive(t *testing.T) {
+	client, server := tcpPair(t)
+	var mu sync.Mutex
+	var changes []string
+	h := Heartbeat{
+		Interval:  50 * time.Millisecond,
+		MaxMissed: 2,
+		OnStateChange: func(from, to HeartbeatState) {
+			mu.Lock()
+			changes = append(changes, from.String()+"->"+to.String())
+			mu.Unlock()
+		},
+	}
+	ctx := context.Background()
+	c := h.Wrap(ctx, client)
+	defer c.Close()
+	s := h.Wrap(ctx, server)
+	defer s.Close()
+
+	// 双方都不发送数据，只靠ping和pong保持连接，状态不应该有任何变化
+	time.Sleep(500 * time.Millisecond)
+	if c.State() != Alive || s.State() != Alive {
+		t.Fatalf("expected both peers alive; actual %s and %s", c.State(), s.State())
+	}
+	mu.Lock()
+	if len(changes) > 0 {
+		t.Errorf("expected no state changes on an idle pair; actual %v", changes)
+	}
+	mu.Unlock()
+
+	_, err := c.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	_ = s.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := s.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("expected %q; actual %q", "hello", buf[:n])
+	}
+}
+
+func TestHeartbeatUnresponsivePeer(t *testing.T) {
+	client, server := tcpPair(t)
+	defer server.Close()
+	// 对端从不应答，只是把ping读掉
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+
+	var mu sync.Mutex
+	var states []HeartbeatState
+	h := Heartbeat{
+		Interval:  50 * time.Millisecond,
+		MaxMissed: 3,
+		OnStateChange: func(_, to HeartbeatState) {
+			mu.Lock()
+			states = append(states, to)
+			mu.Unlock()
+		},
+	}
+	begin := time.Now()
+	c := h.Wrap(context.Background(), client)
+	defer c.Close()
+
+	_, err := c.Read(make([]byte, 1))
+	var uErr *UnresponsiveError
+	if !errors.As(err, &uErr) {
+		t.Fatalf("expected an UnresponsiveError; actual %v", err)
+	}
+	if uErr.Missed != 3 {
+		t.Errorf("expected 3 missed intervals; actual %d", uErr.Missed)
+	}
+	if end := time.Since(begin); end < 150*time.Millisecond {
+		t.Errorf("peer declared dead too early: %s", end)
+	}
+	if c.State() != Dead {
+		t.Errorf("expected state dead; actual %s", c.State())
+	}
+
+	mu.Lock()
+	if len(states) != 2 || states[0] != Suspect || states[1] != Dead {
+		t.Errorf("expected state changes [suspect dead]; actual %v", states)
+	}
+	mu.Unlock()
+
+	if _, err = c.Write([]byte("x")); err == nil {
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestHeartbeatTrafficCountsAsLiveness(t *testing.T) {
+	client, server := tcpPair(t)
+	defer server.Close()
+
+	// 对端从不回复pong，但持续发送普通数据
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := server.Write([]byte("data")); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+
+	c := Heartbeat{Interval: 50 * time.Millisecond, MaxMissed: 2}.Wrap(context.Background(), client)
+	defer c.Close()
+
+	buf := make([]byte, 64)
+	end := time.Now().Add(300 * time.Millisecond)
+	for time.Now().Before(end) {
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i+4 <= n; i += 4 {
+			if string(buf[i:i+4]) != "data" {
+				t.Fatalf("unexpected data %q", buf[:n])
+			}
+		}
+	}
+	if c.State() != Alive {
+		t.Errorf("expected state alive; actual %s", c.State())
+	}
+}
+
+func TestHeartbeatDeadlineWakesRead(t *testing.T) {
+	client, server := tcpPair(t)
+	defer server.Close()
+	c := Heartbeat{Interval: time.Second}.Wrap(context.Background(), client)
+	defer c.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 16))
+		done <- err
+	}()
+
+	// 已经阻塞的Read也要遵守新设置的截止时间
+	time.Sleep(50 * time.Millisecond)
+	_ = c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	select {
+	case err := <-done:
+		var nErr net.Error
+		if !errors.As(err, &nErr) || !nErr.Timeout() {
+			t.Errorf("expected a timeout; actual %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked Read ignored the new deadline")
+	}
+
+	// 截止时间已过，Read立即返回
+	if _, err := c.Read(make([]byte, 16)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected os.ErrDeadlineExceeded; actual %v", err)
+	}
+}
@@ -2,8 +2,12 @@ package ch3
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"io"
 	"net"
+	"os"
+	"sync"
 	"testing"
 	"time"
 )
@@ -115,3 +119,125 @@ func TestPingerAdvanceDeadline(t *testing.T) {
 		t.Fatalf("excepted EOF at 9 seconds;actual %s", end)
 	}
 }
+
+// pingRecorder records every write and fails the ones listed in fail.
+type pingRecorder struct {
+	mu     sync.Mutex
+	writes [][]byte
+	at     []time.Time
+	fail   map[int]error // write number (from 0) to the error it returns
+}
+
+func (r *pingRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := len(r.writes)
+	r.writes = append(r.writes, append([]byte(nil), p...))
+	r.at = append(r.at, time.Now())
+	if err := r.fail[i]; err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (r *pingRecorder) snapshot() ([][]byte, []time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]byte(nil), r.writes...), append([]time.Time(nil), r.at...)
+}
+
+func TestPingSenderEncoderAndJitter(t *testing.T) {
+	rec := new(pingRecorder)
+	p := &PingSender{
+		// 带序号的帧：4字节长度 + 8字节序号
+		Encode: func(w io.Writer, seq uint64) error {
+			frame := binary.BigEndian.AppendUint32(nil, 8)
+			frame = binary.BigEndian.AppendUint64(frame, seq)
+			_, err := w.Write(frame)
+			return err
+		},
+		Jitter: 0.5,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	reset := make(chan time.Duration, 1)
+	reset <- 20 * time.Millisecond
+	done := make(chan error)
+	begin := time.Now()
+	go func() { done <- p.Run(ctx, rec, reset) }()
+
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	writes, at := rec.snapshot()
+	if len(writes) < 5 {
+		t.Fatalf("expected at least 5 pings; actual %d", len(writes))
+	}
+	if p.Sent() != uint64(len(writes)) || p.WriteErrors() != 0 {
+		t.Errorf("unexpected counters: sent %d, write errors %d", p.Sent(), p.WriteErrors())
+	}
+	last, varied := begin, false
+	for i, w := range writes {
+		if len(w) != 12 || binary.BigEndian.Uint64(w[4:]) != uint64(i+1) {
+			t.Errorf("ping %d: unexpected frame %x", i, w)
+		}
+		// 每次等待在10ms到30ms之间，调度误差允许稍微超出
+		gap := at[i].Sub(last)
+		if gap < 9*time.Millisecond || gap > 100*time.Millisecond {
+			t.Errorf("ping %d: gap %s outside the jittered interval", i, gap)
+		}
+		if d := gap - 20*time.Millisecond; d > 3*time.Millisecond || d < -3*time.Millisecond {
+			varied = true
+		}
+		last = at[i]
+	}
+	if !varied {
+		t.Error("expected jitter to vary the intervals")
+	}
+}
+
+func TestPingSenderBackoff(t *testing.T) {
+	rec := &pingRecorder{fail: map[int]error{
+		1: os.ErrDeadlineExceeded,
+		2: os.ErrDeadlineExceeded,
+		4: errors.New("broken pipe"),
+	}}
+	p := &PingSender{
+		Encode: func(w io.Writer, seq uint64) error {
+			_, err := w.Write(binary.BigEndian.AppendUint64(nil, seq))
+			return err
+		},
+		Backoff: 5 * time.Millisecond,
+	}
+	reset := make(chan time.Duration, 1)
+	reset <- 10 * time.Millisecond
+
+	// 临时错误会重试同一个ping，其他错误会结束PingSender
+	err := p.Run(context.Background(), rec, reset)
+	if err == nil || err.Error() != "broken pipe" {
+		t.Fatalf("expected the permanent write error; actual %v", err)
+	}
+	writes, at := rec.snapshot()
+	seqs := make([]uint64, len(writes))
+	for i, w := range writes {
+		seqs[i] = binary.BigEndian.Uint64(w)
+	}
+	expected := []uint64{1, 2, 2, 2, 3}
+	if len(seqs) != len(expected) {
+		t.Fatalf("expected writes %v; actual %v", expected, seqs)
+	}
+	for i := range expected {
+		if seqs[i] != expected[i] {
+			t.Fatalf("expected writes %v; actual %v", expected, seqs)
+		}
+	}
+	// 第二次重试的退避时间加倍
+	if gap := at[3].Sub(at[2]); gap < 10*time.Millisecond {
+		t.Errorf("expected the backoff to double; second retry after %s", gap)
+	}
+	if p.Sent() != 2 || p.WriteErrors() != 3 {
+		t.Errorf("expected 2 pings sent and 3 write errors; actual %d and %d", p.Sent(), p.WriteErrors())
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	ProtocolVersion    uint8 = 1 // the highest version this side speaks
+	MinProtocolVersion uint8 = 1 // the lowest version this side accepts
+)
+
+var ErrIncompatible = errors.New("incompatible peer")
+
+// Handshake is the optional frame peers exchange right after connecting.
+// It advertises the protocol version, the payload types the sender can
+// decode and the largest payload it is willing to receive.
+type Handshake struct {
+	Version        uint8
+	Types          []uint8
+	MaxPayloadSize uint32
+}
+
+// DefaultHandshake describes everything this implementation supports.
+func DefaultHandshake() Handshake {
+	return Handshake{
+		Version:        ProtocolVersion,
+		Types:          []uint8{BinaryType, StringType, StreamType},
+		MaxPayloadSize: MaxPayloadSize,
+	}
+}
+
+func (h Handshake) String() string {
+	return fmt.Sprintf("v%d types=%v max=%d", h.Version, h.Types, h.MaxPayloadSize)
+}
+
+func (h Handshake) Bytes() []byte {
+	// version + max payload size + type count + types
+	b := make([]byte, 1+4+1+len(h.Types))
+	b[0] = h.Version
+	binary.BigEndian.PutUint32(b[1:5], h.MaxPayloadSize)
+	b[5] = uint8(len(h.Types))
+	copy(b[6:], h.Types)
+	return b
+}
+
+func (h Handshake) WriteTo(w io.Writer) (int64, error) {
+	if len(h.Types) > 255 {
+		return 0, errors.New("too many payload types")
+	}
+	err := binary.Write(w, binary.BigEndian, HandshakeType) // 1-byte type
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64 = 1
+	v := h.Bytes()
+	err = binary.Write(w, binary.BigEndian, uint32(len(v))) // 4-byte size
+	if err != nil {
+		return n, err
+	}
+	n += 4
+
+	o, err := w.Write(v) // payload
+	return n + int64(o), err
+}
+
+func (h *Handshake) ReadFrom(r io.Reader) (int64, error) {
+	var typ uint8
+	err := binary.Read(r, binary.BigEndian, &typ)
+	if err != nil {
+		return 0, err
+	}
+	var n int64 = 1
+	if typ != HandshakeType {
+		return n, errors.New("invalid Handshake")
+	}
+
+	var size uint32
+	err = binary.Read(r, binary.BigEndian, &size)
+	if err != nil {
+		return n, err
+	}
+	n += 4
+	// 握手帧最多包含255个类型字节，超过这个长度一定是非法数据
+	if size < 6 || size > 6+255 {
+		return n, errors.New("invalid Handshake")
+	}
+
+	buf := make([]byte, size)
+	o, err := io.ReadFull(r, buf)
+	n += int64(o)
+	if err != nil {
+		return n, err
+	}
+	if int(buf[5]) != len(buf)-6 {
+		return n, errors.New("invalid Handshake")
+	}
+
+	h.Version = buf[0]
+	h.MaxPayloadSize = binary.BigEndian.Uint32(buf[1:5])
+	h.Types = append([]uint8(nil), buf[6:]...)
+
+	return n, nil
+}
+
+// Supports reports whether typ is one of the advertised payload types.
+func (h Handshake) Supports(typ uint8) bool {
+	return bytes.IndexByte(h.Types, typ) >= 0
+}
+
+// Negotiate returns the settings both peers can live with: the lower of
+// the two versions, the payload types both understand and the smaller
+// maximum payload size. It returns an error wrapping ErrIncompatible if
+// no such settings exist.
+func Negotiate(local, remote Handshake) (Handshake, error) {
+	version := local.Version
+	if remote.Version < version {
+		version = remote.Version
+	}
+	if version < MinProtocolVersion {
+		return Handshake{}, fmt.Errorf("%w: protocol version %d not supported (local v%d, remote v%d)",
+			ErrIncompatible, version, local.Version, remote.Version)
+	}
+
+	var types []uint8
+	for _, typ := range local.Types {
+		if remote.Supports(typ) && bytes.IndexByte(types, typ) < 0 {
+			types = append(types, typ)
+		}
+	}
+	if len(types) == 0 {
+		return Handshake{}, fmt.Errorf("%w: no common payload types (local %v, remote %v)",
+			ErrIncompatible, local.Types, remote.Types)
+	}
+
+	max := local.MaxPayloadSize
+	if remote.MaxPayloadSize < max {
+		max = remote.MaxPayloadSize
+	}
+	if max == 0 {
+		return Handshake{}, fmt.Errorf("%w: zero maximum payload size", ErrIncompatible)
+	}
+
+	return Handshake{Version: version, Types: types, MaxPayloadSize: max}, nil
+}
+
+// handshake sends local to the peer, reads the peer's Handshake and
+// negotiates the common settings. Both peers may call it at the same
+// time; the write happens in its own goroutine so neither side blocks
+// waiting for the other to read first. If reading fails, handshake returns
+// the error without waiting for the write, which may be blocked on a peer
+// that never reads; closing the connection ends it.
+func handshake(rw io.ReadWriter, local Handshake) (Handshake, error) {
+	errc := make(chan error, 1)
+	go func() {
+		_, err := local.WriteTo(rw)
+		errc <- err
+	}()
+
+	var remote Handshake
+	_, err := remote.ReadFrom(rw)
+	if err != nil {
+		return Handshake{}, fmt.Errorf("reading handshake: %w", err)
+	}
+	if err = <-errc; err != nil {
+		return Handshake{}, fmt.Errorf("sending handshake: %w", err)
+	}
+
+	return Negotiate(local, remote)
+}
+
+// Decode reads the next payload from r, rejecting payload types and sizes
+// outside the negotiated settings in h. It returns ErrStreamPayload if the
+// payload is a stream; use Next on connections that may carry streams.
+func (h Handshake) Decode(r io.Reader) (Payload, error) {
+	payload, stream, err := h.Next(r)
+	if err != nil {
+		return nil, err
+	}
+	if stream != nil {
+		return nil, ErrStreamPayload
+	}
+	return payload, nil
+}
+
+// Next is like Decode, but it also accepts streams. A stream has no size
+// limit, so it isn't read into a Payload: Next returns a StreamReader
+// positioned at its first frame instead. Read the stream to its end before
+// calling Next again, so the connection stays in sync.
+func (h Handshake) Next(r io.Reader) (Payload, *StreamReader, error) {
+	var header [5]byte // 1-byte type + 4-byte size
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	typ := header[0]
+	if !h.Supports(typ) {
+		return nil, nil, fmt.Errorf("payload type %d not negotiated", typ)
+	}
+	if size := binary.BigEndian.Uint32(header[1:]); size > h.MaxPayloadSize {
+		return nil, nil, ErrMaxPayloadSize
+	}
+
+	r = io.MultiReader(bytes.NewReader(header[:]), r)
+	if typ == StreamType {
+		// 流可能很长，不能当作一个payload读入内存，交给StreamReader从帧头开始读
+		return nil, NewStreamReader(r), nil
+	}
+	payload, err := decode(r)
+	return payload, nil, err
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandshake(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 服务器只支持String类型，并且只接受较小的payload
+	server := Handshake{
+		Version:        ProtocolVersion,
+		Types:          []uint8{StringType},
+		MaxPayloadSize: 1 << 10,
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		agreed, err := handshake(conn, server)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		payload, err := agreed.Decode(conn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if expected := String("Errors are values."); !reflect.DeepEqual(&expected, payload) {
+			t.Errorf("value mismatch: %v != %v", expected, payload)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	agreed, err := handshake(conn, DefaultHandshake())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Handshake{
+		Version:        ProtocolVersion,
+		Types:          []uint8{StringType},
+		MaxPayloadSize: 1 << 10,
+	}
+	if !reflect.DeepEqual(expected, agreed) {
+		t.Fatalf("expected %v; actual %v", expected, agreed)
+	}
+	t.Logf("negotiated %v", agreed)
+
+	_, err = String("Errors are values.").WriteTo(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
+
+func TestHandshakeIncompatible(t *testing.T) {
+	testCases := []struct {
+		local  Handshake
+		remote Handshake
+	}{
+		{ // the remote peer only speaks a version we don't accept
+			DefaultHandshake(),
+			Handshake{Version: 0, Types: []uint8{BinaryType}, MaxPayloadSize: 1},
+		},
+		{ // no payload type in common
+			Handshake{Version: 1, Types: []uint8{BinaryType}, MaxPayloadSize: 1},
+			Handshake{Version: 1, Types: []uint8{StringType}, MaxPayloadSize: 1},
+		},
+	}
+
+	for i, c := range testCases {
+		_, err := Negotiate(c.local, c.remote)
+		if !errors.Is(err, ErrIncompatible) {
+			t.Errorf("%d: expected ErrIncompatible; actual %v", i, err)
+			continue
+		}
+		t.Logf("%d: %v", i, err)
+	}
+}
+
+func TestHandshakeReadError(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	// 对端不是这个协议：只发送其他数据，从不读取我们的握手
+	go func() { _, _ = Binary("not a handshake").WriteTo(server) }()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := handshake(client, DefaultHandshake())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "reading handshake") {
+			t.Errorf("expected a read error; actual %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handshake waited for a write the peer never reads")
+	}
+	_ = client.Close()
+}
+
+func TestHandshakeDecodeLimits(t *testing.T) {
+	agreed := Handshake{Version: 1, Types: []uint8{BinaryType}, MaxPayloadSize: 4}
+
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		_, _ = String("not negotiated").WriteTo(client)
+		_, _ = Binary("too large").WriteTo(client)
+	}()
+
+	if _, err := agreed.Decode(server); err == nil {
+		t.Error("expected error decoding a payload type that was not negotiated")
+	}
+	// discard the rest of the String payload
+	buf := make([]byte, len("not negotiated"))
+	if _, err := server.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := agreed.Decode(server); err != ErrMaxPayloadSize {
+		t.Errorf("expected ErrMaxPayloadSize; actual %v", err)
+	}
+}
+
+func TestHandshakeDecodeStream(t *testing.T) {
+	agreed := DefaultHandshake()
+
+	client, server := net.Pipe()
+	defer server.Close()
+	data := strings.Repeat("stream data ", 10<<10)
+	go func() {
+		defer client.Close()
+		_, _ = WriteStream(client, strings.NewReader(data))
+		_, _ = Binary("after the stream").WriteTo(client)
+	}()
+
+	payload, stream, err := agreed.Next(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload != nil || stream == nil {
+		t.Fatalf("expected a stream; actual payload %v", payload)
+	}
+	actual, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, []byte(data)) {
+		t.Fatalf("expected %d bytes of stream data; actual %d", len(data), len(actual))
+	}
+
+	// 读完流后连接仍然同步，可以继续解码下一个payload
+	payload, stream, err = agreed.Next(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream != nil {
+		t.Fatal("expected a payload; actual a stream")
+	}
+	if expected := Binary("after the stream"); !reflect.DeepEqual(&expected, payload) {
+		t.Errorf("value mismatch: %v != %v", expected, payload)
+	}
+}
+
+func TestHandshakeDecodeRejectsStream(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		_, _ = WriteStream(client, strings.NewReader("stream data"))
+	}()
+
+	if _, err := DefaultHandshake().Decode(server); !errors.Is(err, ErrStreamPayload) {
+		t.Errorf("expected ErrStreamPayload; actual %v", err)
+	}
+}
@@ -1,42 +1,28 @@
 package main
 
 import (
+	"context"
 	"io"
 	"log"
 	"net"
+	"networkProgram/ch3"
+	"networkProgram/ch4/monitor"
 	"os"
 	"testing"
+	"time"
 )
 
-// Monitor embeds a log.Logger meant for logging network traffic
-type Monitor struct {
-	*log.Logger
-}
-
-// Write implements the io.Writer interface
-func (m *Monitor) Write(p []byte) (int, error) {
-	err := m.Output(2, string(p))
-	if err != nil {
-		log.Println(err)
-	}
-	return len(p), nil
-}
-
 func TestMonitor(t *testing.T) {
-	monitor := &Monitor{Logger: log.New(os.Stdout, "monitor: ", 0)}
+	monitor := &monitor.Monitor{Logger: log.New(os.Stdout, "monitor: ", 0)}
 	listener, err := net.Listen("tcp", "127.0.0.1:")
 	if err != nil {
 		monitor.Fatal(err)
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 	done := make(chan struct{})
-	go func() {
-		defer close(done)
-
-		conn, err := listener.Accept()
-		if err != nil {
-			return
-		}
-		defer conn.Close()
+	// 关闭服务器时给处理程序留出时间处理已经收到的消息
+	srv := &ch3.Server{ShutdownGrace: time.Second}
+	srv.Handler = ch3.HandlerFunc(func(_ context.Context, conn net.Conn) {
 		b := make([]byte, 1024)
 		// io.TeeReader 的作用是在从原始
 		// io.Reader（这里是连接）读取数据的同时，
@@ -55,6 +41,10 @@ func TestMonitor(t *testing.T) {
 			monitor.Println(err)
 			return
 		}
+	})
+	go func() {
+		defer close(done)
+		_ = srv.Serve(ctx, listener)
 	}()
 
 	conn, err := net.Dial("tcp", listener.Addr().String())
@@ -68,5 +58,6 @@ func TestMonitor(t *testing.T) {
 	}
 
 	_ = conn.Close()
+	cancel()
 	<-done
 }
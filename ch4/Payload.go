@@ -11,6 +11,8 @@ import (
 const (
 	BinaryType uint8 = iota + 1
 	StringType
+	HandshakeType
+	StreamType
 
 	MaxPayloadSize uint32 = 10 << 20 // 10MB
 )
@@ -144,6 +146,8 @@ func decode(r io.Reader) (Payload, error) {
 		payload = new(Binary)
 	case StringType:
 		payload = new(String)
+	case HandshakeType:
+		payload = new(Handshake)
 	default:
 		return nil, errors.New("unknown type")
 	}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"os/signal"
+	"strings"
 	"time"
 )
 
@@ -14,11 +18,29 @@ var (
 	interval = flag.Duration("i", time.Second, "interval between pings")
 
 	timeout = flag.Duration("W", 5*time.Second, "time to wait for a reply")
+
+	keepGoing = flag.Bool("k", false, "keep pinging after non-temporary errors")
+
+	targetFile = flag.String("f", "", "file with one host:port per line")
+
+	workers = flag.Int("j", 10, "maximum number of concurrent probes")
+
+	format = flag.String("o", "text", "output format: text, json or csv")
+
+	mode = flag.String("m", "connect",
+		"connect: time the TCP handshake; echo: time messages on one TCP connection; "+
+			"udp: time messages to a UDP echo server; happy: race IPv6 and IPv4 (RFC 8305)")
+
+	ipv4 = flag.Bool("4", false, "use IPv4 only")
+
+	ipv6 = flag.Bool("6", false, "use IPv6 only")
+
+	allAddrs = flag.Bool("a", false, "probe every resolved address of each host separately")
 )
 
 func init() {
 	flag.Usage = func() {
-		fmt.Printf("Usage: %s [option] host:port\nOptions:\n", os.Args[0])
+		fmt.Printf("Usage: %s [option] host:port [host:port ...]\nOptions:\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 }
@@ -26,38 +48,178 @@ func init() {
 func main() {
 	flag.Parse()
 
-	if flag.NArg() != 1 {
+	names, err := loadTargets(flag.Args(), *targetFile)
+	if err != nil {
+		fmt.Printf("%v\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if len(names) == 0 {
 		fmt.Print("host:port is required\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
+	if *ipv4 && *ipv6 {
+		fmt.Print("-4 and -6 are mutually exclusive\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	base := "tcp"
+	if *mode == "udp" {
+		base = "udp"
+	}
+	targets, err := expandTargets(names, familyNetwork(base, *ipv4, *ipv6), *allAddrs, *timeout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	out, err := newResultWriter(*format, os.Stdout, len(targets) > 1)
+	if err != nil {
+		fmt.Printf("%v\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
 
-	target := flag.Arg(0)
-	fmt.Println("PING", target)
+	// 机器可读的格式只往stdout写结果，提示和统计信息写到stderr
+	var info io.Writer = os.Stdout
+	if *format != "text" {
+		info = os.Stderr
+	}
+
+	fmt.Fprintln(info, "PING", strings.Join(names, " "))
 
 	if *count <= 0 {
-		fmt.Println("CTRL+C to stop.")
+		fmt.Fprintln(info, "CTRL+C to stop.")
 	}
 
-	msg := 0
-	for (*count <= 0) || (msg < *count) {
-		msg++
-		fmt.Print(msg, " ")
-		start := time.Now()
-		c, err := net.DialTimeout("tcp", target, *timeout)
-		dur := time.Since(start)
+	// 收到Ctrl+C后停止发送，并像ping(8)一样打印统计信息
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
 
+	stats := make(map[string]*pingStats, len(targets))
+	probers := make(map[string]prober, len(targets))
+	for _, target := range targets {
+		stats[target.name] = new(pingStats)
+		probers[target.name], err = newProber(*mode, target, *timeout)
 		if err != nil {
-			fmt.Printf("fail in %s: %v\n", dur, err)
-			if nErr, ok := err.(net.Error); !ok || !nErr.Temporary() {
-				os.Exit(1)
+			fmt.Printf("%v\n\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	defer func() {
+		for _, p := range probers {
+			p.close()
+		}
+		_ = out.flush()
+		failed := false
+		for _, target := range targets {
+			stats[target.name].summary(info, target.name)
+			failed = failed || stats[target.name].succeeded == 0
+		}
+		if failed {
+			os.Exit(1)
+		}
+	}()
+
+	type job struct {
+		target string
+		seq    int
+	}
+	jobs := make(chan job)
+	results := make(chan pingResult)
+	// 不关闭jobs：提前返回时本轮的发送goroutine可能还在发送，关闭会导致panic
+	done := make(chan struct{})
+	defer close(done)
+
+	// 固定数量的worker，限制同时进行的探测数量
+	n := *workers
+	if n < 1 {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		go func() {
+			for {
+				select {
+				case j := <-jobs:
+					select {
+					case results <- probers[j.target].probe(j.seq):
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	var active []string
+	for _, target := range targets {
+		active = append(active, target.name)
+	}
+	for seq := 1; (*count <= 0) || (seq <= *count); seq++ {
+		round := active
+		go func(seq int) {
+			for _, target := range round {
+				select {
+				case jobs <- job{target: target, seq: seq}:
+				case <-done:
+					return
+				}
 			}
-		} else {
-			_ = c.Close()
-			fmt.Println(dur)
+		}(seq)
+
+		active = nil
+		for range round {
+			r := <-results
+			stats[r.Target].add(r)
+			if err := out.write(r); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			if nErr, ok := r.Err.(net.Error); r.Err != nil && !*keepGoing &&
+				(!ok || !nErr.Temporary()) {
+				continue // stop probing this target
+			}
+			active = append(active, r.Target)
+		}
+
+		if len(active) == 0 || (*count > 0 && seq == *count) {
+			return
+		}
+		select {
+		case <-interrupt:
+			return
+		case <-time.After(*interval):
 		}
+	}
 
-		time.Sleep(*interval)
+}
+
+// loadTargets combines the targets given as arguments with those listed in
+// file, one per line. Blank lines and lines starting with # are ignored.
+func loadTargets(args []string, file string) ([]string, error) {
+	targets := append([]string(nil), args...)
+	if file == "" {
+		return targets, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
 	}
+	defer func() { _ = f.Close() }()
 
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+	return targets, scanner.Err()
 }
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"networkProgram/ch4/ping"
+	"strings"
+	"time"
+)
+
+// connectionAttemptDelay is the RFC 8305 recommended time to wait for an
+// attempt before starting the next one.
+const connectionAttemptDelay = 250 * time.Millisecond
+
+// probeTarget is one thing the ping tool measures.
+type probeTarget struct {
+	name    string // shown in the output
+	network string // tcp, tcp4 or tcp6 (udp, udp4 or udp6 in udp mode)
+	addr    string // host:port to dial
+}
+
+// familyNetwork appends the address family to a base network such as tcp.
+func familyNetwork(base string, v4, v6 bool) string {
+	switch {
+	case v4:
+		return base + "4"
+	case v6:
+		return base + "6"
+	default:
+		return base
+	}
+}
+
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// resolve looks up the addresses of host limited to network's address
+// family and reports how long the lookup took. IP literals skip DNS and
+// take no time.
+func resolve(ctx context.Context, network, host string) ([]net.IP, time.Duration, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if (strings.HasSuffix(network, "4") && ip.To4() == nil) ||
+			(strings.HasSuffix(network, "6") && ip.To4() != nil) {
+			return nil, 0, fmt.Errorf("%s is not a %s address", host, network)
+		}
+		return []net.IP{ip}, 0, nil
+	}
+
+	family := "ip"
+	switch {
+	case strings.HasSuffix(network, "4"):
+		family = "ip4"
+	case strings.HasSuffix(network, "6"):
+		family = "ip6"
+	}
+
+	start := time.Now()
+	ips, err := net.DefaultResolver.LookupIP(ctx, family, host)
+	return ips, time.Since(start), err
+}
+
+// expandTargets turns the host:port arguments into probe targets. With all
+// set, each resolved address of a host becomes a target of its own so a
+// broken address can't hide behind a working one.
+func expandTargets(names []string, network string, all bool, timeout time.Duration) ([]probeTarget, error) {
+	var targets []probeTarget
+	for _, name := range names {
+		if !all {
+			targets = append(targets, probeTarget{name: name, network: network, addr: name})
+			continue
+		}
+
+		host, port, err := net.SplitHostPort(name)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		ips, _, err := resolve(ctx, network, host)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			targets = append(targets, probeTarget{
+				name:    fmt.Sprintf("%s (%s)", name, ip),
+				network: network,
+				addr:    net.JoinHostPort(ip.String(), port),
+			})
+		}
+	}
+	return targets, nil
+}
+
+// probe measures how long it takes to establish a TCP connection to the
+// target. Name resolution is timed separately and isn't part of Latency.
+// Like net.Dial, it tries the resolved addresses in turn until one
+// accepts.
+func probe(t probeTarget, seq int, timeout time.Duration) pingResult {
+	start := time.Now()
+	r := pingResult{Time: start, Target: t.name, Seq: seq}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	host, port, err := net.SplitHostPort(t.addr)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	ips, dns, err := resolve(ctx, t.network, host)
+	r.DNS = dns
+	if err != nil {
+		r.Latency = time.Since(start)
+		r.Err = err
+		return r
+	}
+	var ip net.IP
+	ip, r.Latency, r.Err = connectAny(ctx, t.network, ips, port)
+	r.IP = ip.String()
+	return r
+}
+
+// connectAny connects to each of ips in turn until one succeeds, returning
+// that address and the latency of the successful attempt. If every attempt
+// fails, it returns the last address tried, the time spent on all the
+// attempts and all the errors.
+func connectAny(ctx context.Context, network string, ips []net.IP, port string) (net.IP, time.Duration, error) {
+	var total time.Duration
+	var errs []error
+	for _, ip := range ips {
+		latency, err := ping.Connect(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			// 失败的尝试不算在连接延迟里
+			return ip, latency, nil
+		}
+		total += latency
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			// 超时后不用再尝试剩下的地址
+			return ip, total, errors.Join(errs...)
+		}
+	}
+	return ips[len(ips)-1], total, errors.Join(errs...)
+}
+
+// happyProber races connections to a host's IPv6 and IPv4 addresses the
+// way RFC 8305 describes and reports which family won, along with the
+// connect latency of each family.
+type happyProber struct {
+	target  probeTarget
+	timeout time.Duration
+}
+
+func (h happyProber) close() {}
+
+func (h happyProber) probe(seq int) pingResult {
+	start := time.Now()
+	r := pingResult{Time: start, Target: h.target.name, Seq: seq}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	host, port, err := net.SplitHostPort(h.target.addr)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	ips, dns, err := resolve(ctx, h.target.network, host)
+	r.DNS = dns
+	if err != nil {
+		r.Latency = time.Since(start)
+		r.Err = err
+		return r
+	}
+
+	attempts, winner := happyEyeballs(ctx, h.target.network, interleave(ips), port)
+	r.FamilyLatency = make(map[string]time.Duration)
+	for _, a := range attempts {
+		family := ipFamily(a.ip)
+		if _, ok := r.FamilyLatency[family]; !ok && a.err == nil {
+			r.FamilyLatency[family] = a.latency
+		}
+	}
+
+	if winner < 0 {
+		var errs []error
+		for _, a := range attempts {
+			errs = append(errs, a.err)
+		}
+		r.Latency = time.Since(start) - dns
+		r.Err = errors.Join(errs...)
+		if len(attempts) > 0 {
+			r.IP = attempts[0].ip.String()
+		}
+		return r
+	}
+	w := attempts[winner]
+	r.IP = w.ip.String()
+	r.Family = ipFamily(w.ip)
+	r.Latency = w.latency
+	return r
+}
+
+// interleave orders addresses IPv6 first, alternating families after that,
+// as RFC 8305 section 4 recommends.
+func interleave(ips []net.IP) []net.IP {
+	var v6, v4 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	out := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			out = append(out, v6[i])
+		}
+		if i < len(v4) {
+			out = append(out, v4[i])
+		}
+	}
+	return out
+}
+
+type attempt struct {
+	ip      net.IP
+	latency time.Duration
+	err     error
+}
+
+// happyEyeballs starts a connection attempt to each address in turn,
+// starting the next one once the previous attempt fails or
+// connectionAttemptDelay passes, whichever comes first. The first attempt
+// to connect wins, and no further addresses are tried after that, except
+// that each address family always gets one attempt so its latency can be
+// reported. Once every family with an attempt still running has
+// connected, the remaining attempts are canceled. It returns the finished
+// attempts in the order they finished and the index of the winner, or -1
+// if every attempt failed. All connections are closed.
+func happyEyeballs(ctx context.Context, network string, ips []net.IP, port string) ([]attempt, int) {
+	type result struct {
+		attempt
+		conn net.Conn
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result, len(ips))
+	tried := make(map[string]bool)
+	running := make(map[string]int) // attempts in progress per family
+	connected := make(map[string]bool)
+	next, pending := 0, 0
+
+	dial := func(ip net.IP) {
+		pending++
+		tried[ipFamily(ip)] = true
+		running[ipFamily(ip)]++
+		go func() {
+			var d net.Dialer
+			s := time.Now()
+			c, err := d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			results <- result{
+				attempt: attempt{ip: ip, latency: time.Since(s), err: err},
+				conn:    c,
+			}
+		}()
+	}
+	startNext := func() bool {
+		if next >= len(ips) {
+			return false
+		}
+		dial(ips[next])
+		next++
+		return true
+	}
+
+	timer := time.NewTimer(connectionAttemptDelay)
+	defer timer.Stop()
+	startNext()
+
+	var attempts []attempt
+	winner := -1
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if winner < 0 && startNext() {
+				timer.Reset(connectionAttemptDelay)
+			}
+		case res := <-results:
+			pending--
+			running[ipFamily(res.ip)]--
+			if res.err == nil {
+				connected[ipFamily(res.ip)] = true
+			}
+			attempts = append(attempts, res.attempt)
+			if res.conn != nil {
+				_ = res.conn.Close()
+			}
+			switch {
+			case res.err == nil && winner < 0:
+				winner = len(attempts) - 1
+				// 测量用：确保每个地址族都至少尝试一次
+				for _, ip := range ips[next:] {
+					if !tried[ipFamily(ip)] {
+						dial(ip)
+					}
+				}
+				next = len(ips)
+			case res.err != nil && winner < 0:
+				// 上一次尝试失败了，不必等待，立即开始下一次尝试
+				if startNext() {
+					if !timer.Stop() {
+						select {
+						case <-timer.C:
+						default:
+						}
+					}
+					timer.Reset(connectionAttemptDelay)
+				}
+			}
+			if winner >= 0 && measured(running, connected) {
+				// 每个地址族的延迟都已测到，剩下的尝试没有用了
+				cancel()
+			}
+		}
+	}
+
+	return attempts, winner
+}
+
+// measured reports whether every family with attempts still running has
+// connected at least once.
+func measured(running map[string]int, connected map[string]bool) bool {
+	for family, n := range running {
+		if n > 0 && !connected[family] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInterleave(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("192.0.2.1"),
+		net.ParseIP("192.0.2.2"),
+		net.ParseIP("2001:db8::1"),
+	}
+	expected := []net.IP{ips[2], ips[0], ips[1]}
+	if actual := interleave(ips); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %v; actual %v", expected, actual)
+	}
+}
+
+func TestHappyProber(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// 没有程序在[::1]上监听这个端口，IPv6的尝试应该立即失败，由IPv4胜出
+	ips := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}
+	attempts, winner := happyEyeballs(context.Background(), "tcp", interleave(ips), port)
+	if winner < 0 {
+		t.Fatalf("expected a winner; attempts: %v", attempts)
+	}
+	if family := ipFamily(attempts[winner].ip); family != "ipv4" {
+		t.Fatalf("expected ipv4 to win; actual %s", family)
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 attempts; actual %d", len(attempts))
+	}
+	for _, a := range attempts {
+		t.Logf("%s: %s %v", a.ip, a.latency, a.err)
+	}
+
+	p := happyProber{
+		target:  probeTarget{name: "localhost", network: "tcp", addr: listener.Addr().String()},
+		timeout: time.Second,
+	}
+	r := p.probe(1)
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if r.Family != "ipv4" || r.FamilyLatency["ipv4"] != r.Latency {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+}
+
+func TestConnectAny(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// 第一个地址拒绝连接时应该尝试下一个地址，而不是报告失败
+	ips := []net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.1")}
+	ip, _, err := connectAny(context.Background(), "tcp", ips, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(ips[1]) {
+		t.Errorf("expected %s to answer; actual %s", ips[1], ip)
+	}
+
+	_, _, err = connectAny(context.Background(), "tcp", ips[:1], port)
+	if err == nil {
+		t.Error("expected an error when no address accepts")
+	}
+}
+
+func TestResolveFamily(t *testing.T) {
+	_, _, err := resolve(context.Background(), "tcp6", "127.0.0.1")
+	if err == nil {
+		t.Fatal("expected an error resolving an IPv4 literal for tcp6")
+	}
+	ips, dns, err := resolve(context.Background(), "tcp4", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dns != 0 || len(ips) != 1 {
+		t.Fatalf("expected the literal back without DNS; actual %v in %s", ips, dns)
+	}
+}
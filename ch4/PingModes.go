@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// pingMessage matches what ch3's Pinger writes, so the echo mode works
+// against anything that echoes or answers those pings.
+var pingMessage = []byte("ping")
+
+// prober measures one target. The ping tool creates one per target, and
+// probes each target at most once at a time, so probers may keep state
+// such as an open connection between rounds.
+type prober interface {
+	probe(seq int) pingResult
+	close()
+}
+
+func newProber(mode string, target probeTarget, timeout time.Duration) (prober, error) {
+	switch mode {
+	case "connect":
+		return connectProber{target: target, timeout: timeout}, nil
+	case "echo":
+		return &echoProber{target: target, timeout: timeout}, nil
+	case "udp":
+		return &udpProber{target: target, timeout: timeout}, nil
+	case "happy":
+		return happyProber{target: target, timeout: timeout}, nil
+	default:
+		return nil, fmt.Errorf("unknown mode %q", mode)
+	}
+}
+
+// connectProber measures the TCP handshake only.
+type connectProber struct {
+	target  probeTarget
+	timeout time.Duration
+}
+
+func (c connectProber) probe(seq int) pingResult { return probe(c.target, seq, c.timeout) }
+func (c connectProber) close()                   {}
+
+// echoProber keeps one TCP connection open and measures the time it takes
+// to write pingMessage and read it back, which says something about the
+// server's responsiveness rather than just the kernel's.
+type echoProber struct {
+	target  probeTarget
+	timeout time.Duration
+	conn    net.Conn
+}
+
+func (e *echoProber) probe(seq int) pingResult {
+	r := pingResult{Time: time.Now(), Target: e.target.name, Seq: seq}
+	if e.conn == nil {
+		// 第一次探测或者上一次失败后重新建立连接，建立连接的时间不计入RTT
+		conn, err := net.DialTimeout(e.target.network, e.target.addr, e.timeout)
+		if err != nil {
+			r.IP, r.Err = errorIP(err), err
+			return r
+		}
+		e.conn = conn
+	}
+	r.IP = addrIP(e.conn.RemoteAddr())
+
+	buf := make([]byte, len(pingMessage))
+	start := time.Now()
+	r.Time = start
+	err := e.conn.SetDeadline(start.Add(e.timeout))
+	if err == nil {
+		_, err = e.conn.Write(pingMessage)
+	}
+	if err == nil {
+		_, err = io.ReadFull(e.conn, buf)
+	}
+	r.Latency = time.Since(start)
+	if err == nil && !bytes.Equal(buf, pingMessage) {
+		err = fmt.Errorf("unexpected reply %q", buf)
+	}
+
+	if err != nil {
+		// 超时或者读到错误数据后，流中的位置已经不可靠，只能重新连接
+		r.Err = err
+		e.close()
+	}
+	return r
+}
+
+func (e *echoProber) close() {
+	if e.conn != nil {
+		_ = e.conn.Close()
+		e.conn = nil
+	}
+}
+
+// udpProber sends pingMessage followed by a 4-byte sequence number to a
+// UDP echo server. Replies carrying an older sequence number arrived after
+// their probe timed out, so they count as late rather than as a reply.
+type udpProber struct {
+	target  probeTarget
+	timeout time.Duration
+	conn    net.Conn
+}
+
+func (u *udpProber) probe(seq int) pingResult {
+	r := pingResult{Time: time.Now(), Target: u.target.name, Seq: seq}
+	if u.conn == nil {
+		conn, err := net.Dial(u.target.network, u.target.addr)
+		if err != nil {
+			r.IP, r.Err = errorIP(err), err
+			return r
+		}
+		u.conn = conn
+	}
+	r.IP = addrIP(u.conn.RemoteAddr())
+
+	msg := make([]byte, len(pingMessage)+4)
+	copy(msg, pingMessage)
+	binary.BigEndian.PutUint32(msg[len(pingMessage):], uint32(seq))
+
+	start := time.Now()
+	r.Time = start
+	err := u.conn.SetReadDeadline(start.Add(u.timeout))
+	if err == nil {
+		_, err = u.conn.Write(msg)
+	}
+
+	buf := make([]byte, 1024)
+	for err == nil {
+		var n int
+		n, err = u.conn.Read(buf)
+		if err != nil {
+			break
+		}
+		if n != len(msg) || !bytes.Equal(buf[:len(pingMessage)], pingMessage) {
+			err = fmt.Errorf("unexpected reply %q", buf[:n])
+			break
+		}
+		replySeq := binary.BigEndian.Uint32(buf[len(pingMessage):n])
+		if replySeq == uint32(seq) {
+			break
+		}
+		// 一个之前已经判定为丢失的回复现在才到达，继续等待本次的回复
+		r.Late++
+	}
+	r.Latency = time.Since(start)
+	r.Err = err
+	return r
+}
+
+func (u *udpProber) close() {
+	if u.conn != nil {
+		_ = u.conn.Close()
+		u.conn = nil
+	}
+}
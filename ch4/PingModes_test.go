@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEchoProber(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+			go func(c net.Conn) {
+				defer c.Close()
+				_, _ = io.Copy(c, c) // echo everything back
+			}(conn)
+		}
+	}()
+
+	addr := listener.Addr().String()
+	p, err := newProber("echo", probeTarget{name: addr, network: "tcp", addr: addr}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+
+	for seq := 1; seq <= 3; seq++ {
+		r := p.probe(seq)
+		if r.Err != nil {
+			t.Fatalf("%d: %v", seq, r.Err)
+		}
+		t.Logf("%d: %s", seq, r.Latency)
+	}
+
+	// every message should have used the same connection
+	if n := len(accepted); n != 1 {
+		t.Fatalf("expected 1 connection; actual %d", n)
+	}
+}
+
+func TestUDPProberLate(t *testing.T) {
+	s, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// 第一个请求的回复会延迟到超时之后，第二个请求立即回复
+	go func() {
+		buf := make([]byte, 1024)
+		for i := 0; ; i++ {
+			n, addr, err := s.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if i == 0 {
+				delayed := append([]byte(nil), buf[:n]...)
+				time.AfterFunc(150*time.Millisecond, func() {
+					_, _ = s.WriteTo(delayed, addr)
+				})
+				continue
+			}
+			_, _ = s.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	addr := s.LocalAddr().String()
+	p, err := newProber("udp", probeTarget{name: addr, network: "udp", addr: addr}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+
+	r := p.probe(1)
+	if errorClass(r.Err) != "timeout" {
+		t.Fatalf("expected first probe to time out; actual %v", r.Err)
+	}
+
+	time.Sleep(100 * time.Millisecond) // let the late reply arrive
+	r = p.probe(2)
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if r.Late != 1 {
+		t.Fatalf("expected 1 late reply; actual %d", r.Late)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// pingResult is the outcome of a single probe of a single target.
+type pingResult struct {
+	Time    time.Time
+	Target  string
+	Seq     int
+	IP      string // the address actually dialed, if known
+	Latency time.Duration
+	Err     error
+	Late    int // replies to earlier probes received while waiting
+
+	DNS           time.Duration            // name resolution, not part of Latency
+	Family        string                   // the address family that won a happy eyeballs race
+	FamilyLatency map[string]time.Duration // connect latency of each family in the race
+}
+
+// details describes the timing beyond Latency, if there is any.
+func (r pingResult) details() string {
+	var parts []string
+	if r.Family != "" {
+		parts = append(parts, "via "+r.Family)
+	}
+	for _, family := range []string{"ipv6", "ipv4"} {
+		if d, ok := r.FamilyLatency[family]; ok {
+			parts = append(parts, fmt.Sprintf("%s %s", family, d))
+		}
+	}
+	if r.DNS > 0 {
+		parts = append(parts, fmt.Sprintf("dns %s", r.DNS))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// errorClass reduces a dial error to a short, stable label that scripts
+// can match on without parsing error messages.
+func errorClass(err error) string {
+	var dnsErr *net.DNSError
+	var nErr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &nErr) && nErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "unreachable"
+	default:
+		return "other"
+	}
+}
+
+// addrIP returns the IP portion of addr, or "" if there isn't one.
+func addrIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// errorIP digs the address a failed dial was attempting out of err.
+func errorIP(err error) string {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return addrIP(opErr.Addr)
+	}
+	return ""
+}
+
+type resultWriter interface {
+	write(r pingResult) error
+	flush() error
+}
+
+func newResultWriter(format string, w io.Writer, multi bool) (resultWriter, error) {
+	switch format {
+	case "text":
+		return &textWriter{w: w, multi: multi}, nil
+	case "json":
+		return &jsonWriter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// textWriter keeps the original human-readable output. With more than one
+// target each line is prefixed with the target it belongs to.
+type textWriter struct {
+	w     io.Writer
+	multi bool
+}
+
+func (t *textWriter) write(r pingResult) error {
+	var err error
+	if t.multi {
+		_, err = fmt.Fprintf(t.w, "%s ", r.Target)
+		if err != nil {
+			return err
+		}
+	}
+	if r.Err != nil {
+		_, err = fmt.Fprintf(t.w, "%d fail in %s: %v%s\n", r.Seq, r.Latency, r.Err, r.details())
+	} else {
+		_, err = fmt.Fprintf(t.w, "%d %s%s\n", r.Seq, r.Latency, r.details())
+	}
+	return err
+}
+
+func (t *textWriter) flush() error { return nil }
+
+// jsonWriter writes one JSON object per line.
+type jsonWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonWriter) write(r pingResult) error {
+	record := struct {
+		Time       time.Time `json:"timestamp"`
+		Target     string    `json:"target"`
+		Seq        int       `json:"seq"`
+		IP         string    `json:"ip,omitempty"`
+		LatencyMS  float64   `json:"latency_ms"`
+		ErrorClass string    `json:"error_class,omitempty"`
+		Error      string    `json:"error,omitempty"`
+		Late       int       `json:"late,omitempty"`
+		DNSMS      float64   `json:"dns_ms,omitempty"`
+		Family     string    `json:"family,omitempty"`
+		IPv4MS     *float64  `json:"ipv4_ms,omitempty"`
+		IPv6MS     *float64  `json:"ipv6_ms,omitempty"`
+	}{
+		Time:       r.Time,
+		Target:     r.Target,
+		Seq:        r.Seq,
+		IP:         r.IP,
+		LatencyMS:  milliseconds(r.Latency),
+		ErrorClass: errorClass(r.Err),
+		Late:       r.Late,
+		DNSMS:      milliseconds(r.DNS),
+		Family:     r.Family,
+	}
+	if d, ok := r.FamilyLatency["ipv4"]; ok {
+		ms := milliseconds(d)
+		record.IPv4MS = &ms
+	}
+	if d, ok := r.FamilyLatency["ipv6"]; ok {
+		ms := milliseconds(d)
+		record.IPv6MS = &ms
+	}
+	if r.Err != nil {
+		record.Error = r.Err.Error()
+	}
+	return j.enc.Encode(record)
+}
+
+func (j *jsonWriter) flush() error { return nil }
+
+// csvWriter writes a header row followed by one row per result.
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvWriter) write(r pingResult) error {
+	if !c.header {
+		c.header = true
+		err := c.w.Write([]string{"timestamp", "target", "seq", "ip",
+			"latency_ms", "error_class", "error", "dns_ms", "family"})
+		if err != nil {
+			return err
+		}
+	}
+
+	var msg string
+	if r.Err != nil {
+		msg = r.Err.Error()
+	}
+	err := c.w.Write([]string{
+		r.Time.Format(time.RFC3339Nano),
+		r.Target,
+		strconv.Itoa(r.Seq),
+		r.IP,
+		strconv.FormatFloat(milliseconds(r.Latency), 'f', 3, 64),
+		errorClass(r.Err),
+		msg,
+		strconv.FormatFloat(milliseconds(r.DNS), 'f', 3, 64),
+		r.Family,
+	})
+	if err != nil {
+		return err
+	}
+	// 每一行都立即刷新，这样即使用Ctrl+C中断，脚本也能拿到完整的行
+	return c.flush()
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProbeErrorClass(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+
+	target := probeTarget{name: addr, network: "tcp", addr: addr}
+	r := probe(target, 1, time.Second)
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if r.IP != "127.0.0.1" {
+		t.Errorf("expected IP 127.0.0.1; actual %q", r.IP)
+	}
+
+	// nothing listens on the port once the listener is closed
+	_ = listener.Close()
+	r = probe(target, 2, time.Second)
+	if class := errorClass(r.Err); class != "refused" {
+		t.Errorf("expected error class %q; actual %q (%v)", "refused", class, r.Err)
+	}
+	if r.IP != "127.0.0.1" {
+		t.Errorf("expected IP 127.0.0.1 from the failed dial; actual %q", r.IP)
+	}
+
+	r = probe(probeTarget{name: "invalid", network: "tcp", addr: "nonexistent.invalid:80"}, 3, time.Second)
+	if class := errorClass(r.Err); class != "dns" {
+		t.Errorf("expected error class %q; actual %q (%v)", "dns", class, r.Err)
+	}
+}
+
+func TestResultWriters(t *testing.T) {
+	results := []pingResult{
+		{Time: time.Unix(0, 0).UTC(), Target: "a:80", Seq: 1, IP: "10.0.0.1",
+			Latency: 1500 * time.Microsecond},
+		{Time: time.Unix(1, 0).UTC(), Target: "b:80", Seq: 1, IP: "10.0.0.2",
+			Latency: time.Second, Err: &net.OpError{Op: "dial", Err: timeoutError{}}},
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := newResultWriter("csv", buf, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if err := w.write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected header and 2 rows; actual %d rows", len(rows))
+	}
+	if row := rows[1]; row[1] != "a:80" || row[3] != "10.0.0.1" || row[4] != "1.500" || row[5] != "" {
+		t.Errorf("unexpected row: %q", row)
+	}
+	if row := rows[2]; row[5] != "timeout" {
+		t.Errorf("unexpected row: %q", row)
+	}
+
+	buf.Reset()
+	w, err = newResultWriter("json", buf, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if err := w.write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dec := json.NewDecoder(buf)
+	for i := range results {
+		var record map[string]interface{}
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		if record["target"] != results[i].Target {
+			t.Errorf("%d: unexpected record: %v", i, record)
+		}
+	}
+
+	if _, err := newResultWriter("xml", buf, false); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// pingStats collects the results of every ping so the tool can print a
+// ping(8)-style summary when it finishes or is interrupted.
+type pingStats struct {
+	sent      int
+	succeeded int
+	failed    int
+	late      int             // replies that arrived after their probe gave up
+	rtts      []time.Duration // successful durations in the order they happened
+	dns       []time.Duration // name resolution times, when DNS was used
+	wins      map[string]int  // happy eyeballs races won by each address family
+}
+
+func (s *pingStats) add(r pingResult) {
+	s.sent++
+	s.late += r.Late
+	if r.DNS > 0 {
+		s.dns = append(s.dns, r.DNS)
+	}
+	if r.Family != "" {
+		if s.wins == nil {
+			s.wins = make(map[string]int)
+		}
+		s.wins[r.Family]++
+	}
+	if r.Err != nil {
+		s.failed++
+		return
+	}
+	s.succeeded++
+	s.rtts = append(s.rtts, r.Latency)
+}
+
+func (s *pingStats) loss() float64 {
+	if s.sent == 0 {
+		return 0
+	}
+	return float64(s.failed) / float64(s.sent) * 100
+}
+
+func (s *pingStats) minAvgMax() (min, avg, max time.Duration) {
+	if len(s.rtts) == 0 {
+		return 0, 0, 0
+	}
+	min, max = s.rtts[0], s.rtts[0]
+	var sum time.Duration
+	for _, d := range s.rtts {
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+		sum += d
+	}
+	return min, sum / time.Duration(len(s.rtts)), max
+}
+
+// stddev is the population standard deviation of the successful durations,
+// the same figure ping(8) reports as mdev.
+func (s *pingStats) stddev() time.Duration {
+	if len(s.rtts) == 0 {
+		return 0
+	}
+	_, avg, _ := s.minAvgMax()
+	var sum float64
+	for _, d := range s.rtts {
+		diff := float64(d - avg)
+		sum += diff * diff
+	}
+	return time.Duration(math.Sqrt(sum / float64(len(s.rtts))))
+}
+
+// percentile uses the nearest-rank method, so the result is always one of
+// the measured durations.
+func (s *pingStats) percentile(p float64) time.Duration {
+	if len(s.rtts) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(s.rtts))
+	copy(sorted, s.rtts)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// jitter is the mean absolute difference between consecutive successful
+// durations.
+func (s *pingStats) jitter() time.Duration {
+	if len(s.rtts) < 2 {
+		return 0
+	}
+	var sum time.Duration
+	for i := 1; i < len(s.rtts); i++ {
+		d := s.rtts[i] - s.rtts[i-1]
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+	return sum / time.Duration(len(s.rtts)-1)
+}
+
+func (s *pingStats) summary(w io.Writer, target string) {
+	fmt.Fprintf(w, "\n--- %s ping statistics ---\n", target)
+	fmt.Fprintf(w, "%d sent, %d succeeded, %d failed, %.1f%% loss\n",
+		s.sent, s.succeeded, s.failed, s.loss())
+	if s.late > 0 {
+		fmt.Fprintf(w, "%d late or reordered replies\n", s.late)
+	}
+	if len(s.dns) > 0 {
+		var sum time.Duration
+		for _, d := range s.dns {
+			sum += d
+		}
+		fmt.Fprintf(w, "dns avg = %s\n", sum/time.Duration(len(s.dns)))
+	}
+	if len(s.wins) > 0 {
+		fmt.Fprintf(w, "ipv6 won %d, ipv4 won %d\n", s.wins["ipv6"], s.wins["ipv4"])
+	}
+	if s.succeeded == 0 {
+		return
+	}
+	min, avg, max := s.minAvgMax()
+	fmt.Fprintf(w, "min/avg/max/stddev = %s/%s/%s/%s\n",
+		min, avg, max, s.stddev())
+	fmt.Fprintf(w, "p50/p90/p99 = %s/%s/%s, jitter = %s\n",
+		s.percentile(50), s.percentile(90), s.percentile(99), s.jitter())
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPingStats(t *testing.T) {
+	stats := new(pingStats)
+	for _, ms := range []int{10, 30, 20, 40, 50, 60, 70, 80, 90, 100} {
+		stats.add(pingResult{Latency: time.Duration(ms) * time.Millisecond})
+	}
+	stats.add(pingResult{Err: errors.New("connection refused")})
+	stats.add(pingResult{Err: errors.New("connection refused"), Late: 1})
+
+	if stats.sent != 12 || stats.succeeded != 10 || stats.failed != 2 {
+		t.Fatalf("unexpected counts: %+v", stats)
+	}
+
+	testCases := []struct {
+		name     string
+		actual   time.Duration
+		expected time.Duration
+	}{
+		{"p50", stats.percentile(50), 50 * time.Millisecond},
+		{"p90", stats.percentile(90), 90 * time.Millisecond},
+		{"p99", stats.percentile(99), 100 * time.Millisecond},
+		// |30-10| + |20-30| + |40-20| + 6 x 10 = 110ms over 9 differences
+		{"jitter", stats.jitter(), 110 * time.Millisecond / 9},
+		{"stddev", stats.stddev(), 28722813},
+	}
+	for _, c := range testCases {
+		if c.actual != c.expected {
+			t.Errorf("%s: expected %s; actual %s", c.name, c.expected, c.actual)
+		}
+	}
+
+	if min, avg, max := stats.minAvgMax(); min != 10*time.Millisecond ||
+		avg != 55*time.Millisecond || max != 100*time.Millisecond {
+		t.Errorf("unexpected min/avg/max: %s/%s/%s", min, avg, max)
+	}
+
+	b := new(strings.Builder)
+	stats.summary(b, "test:80")
+	if !strings.Contains(b.String(), "16.7% loss") ||
+		!strings.Contains(b.String(), "1 late or reordered") {
+		t.Errorf("unexpected summary:\n%s", b)
+	}
+	t.Logf("\n%s", b)
+}
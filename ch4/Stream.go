@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const MaxChunkSize uint32 = 64 << 10 // 64KB
+
+// the first byte of every StreamType frame's value says what it carries
+const (
+	streamChunk uint8 = iota
+	streamEnd
+	streamAbort
+)
+
+var (
+	ErrStreamAborted = errors.New("stream aborted by sender")
+	ErrStreamPayload = errors.New("payload is a stream; read it with Handshake.Next")
+)
+
+// WriteStream sends everything read from r to w as a sequence of StreamType
+// frames of at most MaxChunkSize bytes each, followed by an end-of-stream
+// marker. Unlike Binary, the data is never held in memory all at once, so
+// there is no limit on the total size. If reading from r fails, WriteStream
+// sends an abort frame carrying the error message so the receiver does not
+// mistake a partial stream for a complete one.
+func WriteStream(w io.Writer, r io.Reader) (int64, error) {
+	var n int64
+	buf := make([]byte, MaxChunkSize)
+	for {
+		o, rErr := r.Read(buf)
+		if o > 0 {
+			m, err := writeStreamFrame(w, streamChunk, buf[:o])
+			n += m
+			if err != nil {
+				return n, err
+			}
+		}
+
+		switch {
+		case rErr == io.EOF:
+			m, err := writeStreamFrame(w, streamEnd, nil)
+			return n + m, err
+		case rErr != nil:
+			m, err := writeStreamFrame(w, streamAbort, []byte(rErr.Error()))
+			n += m
+			if err != nil {
+				return n, err
+			}
+			return n, rErr
+		}
+	}
+}
+
+// AbortStream tells the receiver the stream will not be completed.
+func AbortStream(w io.Writer, reason string) error {
+	_, err := writeStreamFrame(w, streamAbort, []byte(reason))
+	return err
+}
+
+func writeStreamFrame(w io.Writer, kind uint8, p []byte) (int64, error) {
+	err := binary.Write(w, binary.BigEndian, StreamType) // 1-byte type
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64 = 1
+	err = binary.Write(w, binary.BigEndian, uint32(1+len(p))) // 4-byte size
+	if err != nil {
+		return n, err
+	}
+	n += 4
+
+	err = binary.Write(w, binary.BigEndian, kind) // 1-byte frame kind
+	if err != nil {
+		return n, err
+	}
+	n++
+
+	o, err := w.Write(p) // chunk
+	return n + int64(o), err
+}
+
+// StreamReader yields the data of a stream written by WriteStream as the
+// chunks arrive. It copies chunk data straight into the caller's buffer,
+// so its memory use does not depend on the chunk or stream size.
+type StreamReader struct {
+	r         io.Reader
+	remaining uint32 // unread bytes of the current chunk
+	err       error  // sticky error returned once the stream ends
+}
+
+func NewStreamReader(r io.Reader) *StreamReader {
+	return &StreamReader{r: r}
+}
+
+// Read returns io.EOF after the end-of-stream marker and an error wrapping
+// ErrStreamAborted if the sender aborted the stream.
+func (s *StreamReader) Read(p []byte) (int, error) {
+	for s.remaining == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.err = s.next(); s.err != nil {
+			return 0, s.err
+		}
+	}
+
+	if uint32(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.r.Read(p)
+	s.remaining -= uint32(n)
+	if err == io.EOF {
+		// 连接在数据块中途被关闭，流不完整
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		s.err = err
+	}
+	return n, err
+}
+
+// next reads the header of the next frame, leaving s.remaining set to the
+// size of a chunk, or returns the error that ends the stream.
+func (s *StreamReader) next() error {
+	var header [6]byte // 1-byte type + 4-byte size + 1-byte frame kind
+	_, err := io.ReadFull(s.r, header[:])
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if header[0] != StreamType {
+		return errors.New("invalid Stream")
+	}
+	size := binary.BigEndian.Uint32(header[1:5])
+	if size == 0 || size-1 > MaxChunkSize {
+		return errors.New("invalid Stream")
+	}
+	size-- // exclude the frame kind
+
+	switch header[5] {
+	case streamChunk:
+		s.remaining = size
+		return nil
+	case streamEnd:
+		if size != 0 {
+			return errors.New("invalid Stream")
+		}
+		return io.EOF
+	case streamAbort:
+		reason := make([]byte, size)
+		_, err = io.ReadFull(s.r, reason)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s", ErrStreamAborted, reason)
+	default:
+		return errors.New("invalid Stream")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	// 3倍于MaxPayloadSize，Binary无法发送这么大的数据
+	const size = 3 * MaxPayloadSize
+
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := sha256.New()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		src := io.TeeReader(io.LimitReader(rand.New(rand.NewSource(1)), int64(size)), expected)
+		_, err = WriteStream(conn, src)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	actual := sha256.New()
+	n, err := io.Copy(actual, NewStreamReader(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if n != int64(size) {
+		t.Fatalf("expected %d bytes; actual %d", size, n)
+	}
+	if !bytes.Equal(expected.Sum(nil), actual.Sum(nil)) {
+		t.Fatal("stream content mismatch")
+	}
+	t.Logf("streamed %d bytes", n)
+}
+
+type failingReader struct{ n int }
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.n == 0 {
+		return 0, errors.New("disk on fire")
+	}
+	if len(p) > f.n {
+		p = p[:f.n]
+	}
+	f.n -= len(p)
+	return len(p), nil
+}
+
+func TestStreamAbort(t *testing.T) {
+	buf := new(bytes.Buffer)
+	_, err := WriteStream(buf, &failingReader{n: 100})
+	if err == nil {
+		t.Fatal("expected read error")
+	}
+
+	b, err := io.ReadAll(NewStreamReader(buf))
+	if !errors.Is(err, ErrStreamAborted) {
+		t.Fatalf("expected ErrStreamAborted; actual %v", err)
+	}
+	if len(b) != 100 {
+		t.Errorf("expected the 100 bytes sent before the abort; actual %d", len(b))
+	}
+	t.Log(err)
+}
+
+func TestStreamTruncated(t *testing.T) {
+	buf := new(bytes.Buffer)
+	_, err := WriteStream(buf, bytes.NewReader(make([]byte, 1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Truncate(buf.Len() - 6) // drop the end-of-stream marker
+
+	_, err = io.ReadAll(NewStreamReader(buf))
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF; actual %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	tftp "networkProgram/ch6"
+	"sort"
+	"strings"
+)
+
+// Decoder describes the traffic in one direction of a connection. Decode
+// is called with each chunk of data as it arrives, which may hold part of
+// a message or several of them, and writes a description of every message
+// it completes to w. A Decoder is used for a single direction of a single
+// connection, so it can keep state between calls.
+type Decoder interface {
+	Decode(w io.Writer, p []byte)
+}
+
+// Decoders maps the names accepted by NewDecoder to their constructors.
+var Decoders = map[string]func() Decoder{
+	"raw":  func() Decoder { return Raw{} },
+	"hex":  func() Decoder { return new(Hexdump) },
+	"tlv":  func() Decoder { return new(TLV) },
+	"tftp": func() Decoder { return TFTP{} },
+}
+
+// NewDecoder returns a new decoder of the named kind.
+func NewDecoder(name string) (Decoder, error) {
+	f, ok := Decoders[name]
+	if !ok {
+		names := make([]string, 0, len(Decoders))
+		for n := range Decoders {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown decoder %q; expected one of %s",
+			name, strings.Join(names, ", "))
+	}
+	return f(), nil
+}
+
+// Raw writes data as it is, the way Monitor always has.
+type Raw struct{}
+
+func (Raw) Decode(w io.Writer, p []byte) { _, _ = w.Write(p) }
+
+// Hexdump writes data in the canonical hex+ASCII format of hexdump -C.
+// Offsets continue from one call to the next.
+type Hexdump struct {
+	offset int
+}
+
+func (h *Hexdump) Decode(w io.Writer, p []byte) {
+	for len(p) > 0 {
+		n := min(len(p), 16)
+		line := p[:n]
+		p = p[n:]
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "%08x ", h.offset)
+		for i := 0; i < 16; i++ {
+			if i == 8 {
+				b.WriteByte(' ')
+			}
+			if i < len(line) {
+				fmt.Fprintf(&b, " %02x", line[i])
+			} else {
+				b.WriteString("   ")
+			}
+		}
+		b.WriteString("  |")
+		for _, c := range line {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		b.WriteString("|\n")
+		_, _ = io.WriteString(w, b.String())
+		h.offset += n
+	}
+}
+
+// The TLV payload types and stream frame kinds from package main of ch4,
+// which can't be imported.
+const (
+	tlvBinary uint8 = iota + 1
+	tlvString
+	tlvHandshake
+	tlvStream
+
+	tlvMaxPayloadSize uint32 = 10 << 20
+)
+
+const (
+	streamChunk uint8 = iota
+	streamEnd
+	streamAbort
+)
+
+// TLV decodes the type-length-value payloads of ch4: a 1-byte type, a
+// 4-byte big endian length and the value. Once it sees a frame it can't
+// make sense of, it falls back to a hexdump for the rest of the data.
+type TLV struct {
+	buf  []byte
+	hex  *Hexdump // set once the framing is lost
+	seen int      // bytes consumed, for the hexdump offsets
+}
+
+func (t *TLV) Decode(w io.Writer, p []byte) {
+	if t.hex != nil {
+		t.hex.Decode(w, p)
+		return
+	}
+	t.buf = append(t.buf, p...)
+	for len(t.buf) >= 5 {
+		typ := t.buf[0]
+		size := binary.BigEndian.Uint32(t.buf[1:5])
+		if typ < tlvBinary || typ > tlvStream || size > tlvMaxPayloadSize {
+			// 帧边界已经无法确定，剩下的数据只能按十六进制输出
+			fmt.Fprintf(w, "invalid frame: type %d, size %d\n", typ, size)
+			t.hex = &Hexdump{offset: t.seen}
+			t.hex.Decode(w, t.buf)
+			t.buf = nil
+			return
+		}
+		if uint32(len(t.buf)-5) < size {
+			return // wait for the rest of the value
+		}
+		value := t.buf[5 : 5+size]
+		describeTLV(w, typ, value)
+		t.seen += 5 + int(size)
+		t.buf = t.buf[5+size:]
+	}
+}
+
+func describeTLV(w io.Writer, typ uint8, v []byte) {
+	switch typ {
+	case tlvBinary:
+		fmt.Fprintf(w, "Binary (%d bytes) % x\n", len(v), excerpt(v, 0))
+	case tlvString:
+		fmt.Fprintf(w, "String (%d bytes) %q\n", len(v), v)
+	case tlvHandshake:
+		// version + max payload size + type count + types
+		if len(v) < 6 || len(v) < 6+int(v[5]) {
+			fmt.Fprintf(w, "Handshake (%d bytes) malformed % x\n", len(v), v)
+			return
+		}
+		fmt.Fprintf(w, "Handshake v%d types=%v max=%d\n",
+			v[0], v[6:6+int(v[5])], binary.BigEndian.Uint32(v[1:5]))
+	case tlvStream:
+		if len(v) == 0 {
+			fmt.Fprintln(w, "Stream malformed: empty frame")
+			return
+		}
+		switch v[0] {
+		case streamChunk:
+			fmt.Fprintf(w, "Stream chunk (%d bytes) % x\n", len(v)-1, excerpt(v[1:], 0))
+		case streamEnd:
+			fmt.Fprintln(w, "Stream end")
+		case streamAbort:
+			fmt.Fprintf(w, "Stream abort %q\n", v[1:])
+		default:
+			fmt.Fprintf(w, "Stream unknown frame kind %d\n", v[0])
+		}
+	}
+}
+
+// TFTP decodes the packets of ch6. It expects each call to carry exactly
+// one datagram, as reads and writes on UDP connections do.
+type TFTP struct{}
+
+func (TFTP) Decode(w io.Writer, p []byte) {
+	if len(p) < 2 {
+		fmt.Fprintf(w, "short packet % x\n", p)
+		return
+	}
+
+	switch op := tftp.OpCode(binary.BigEndian.Uint16(p)); op {
+	case tftp.OpRRQ:
+		var q tftp.ReadReq
+		if err := q.UnmarshalBinary(p); err != nil {
+			fmt.Fprintf(w, "RRQ invalid: %v\n", err)
+			return
+		}
+		fmt.Fprintf(w, "RRQ filename=%q mode=%q\n", q.Filename, q.Mode)
+	case tftp.OpData:
+		var d tftp.Data
+		if err := d.UnmarshalBinary(p); err != nil {
+			fmt.Fprintf(w, "DATA invalid: %v\n", err)
+			return
+		}
+		b, _ := io.ReadAll(d.Payload)
+		fmt.Fprintf(w, "DATA block=%d (%d bytes)\n", d.Block, len(b))
+	case tftp.OpAck:
+		var a tftp.Ack
+		if err := a.UnmarshalBinary(p); err != nil {
+			fmt.Fprintf(w, "ACK invalid: %v\n", err)
+			return
+		}
+		fmt.Fprintf(w, "ACK block=%d\n", a)
+	case tftp.OpErr:
+		var e tftp.Err
+		if err := e.UnmarshalBinary(p); err != nil {
+			fmt.Fprintf(w, "ERROR invalid: %v\n", err)
+			return
+		}
+		fmt.Fprintf(w, "ERROR code=%d message=%q\n", e.Error, e.Message)
+	default:
+		fmt.Fprintf(w, "unknown opcode %d (%d bytes)\n", op, len(p))
+	}
+}
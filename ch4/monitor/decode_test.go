@@ -0,0 +1,134 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"log"
+	"net"
+	tftp "networkProgram/ch6"
+	"strings"
+	"testing"
+)
+
+func TestHexdump(t *testing.T) {
+	data := []byte("The quick brown fox\x00\x01\x02 jumps over the lazy dog\xff")
+	// 数据分块到达时偏移量必须连续
+	for _, size := range []int{16, 32, len(data)} {
+		h := new(Hexdump)
+		actual := new(bytes.Buffer)
+		for p := data; len(p) > 0; p = p[min(len(p), size):] {
+			h.Decode(actual, p[:min(len(p), size)])
+		}
+		if expected := hex.Dump(data); actual.String() != expected {
+			t.Errorf("chunks of %d:\nexpected:\n%s\nactual:\n%s", size, expected, actual)
+		}
+	}
+}
+
+func tlv(typ uint8, v []byte) []byte {
+	b := []byte{typ, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], uint32(len(v)))
+	return append(b, v...)
+}
+
+func TestTLV(t *testing.T) {
+	var stream []byte
+	stream = append(stream, tlv(tlvHandshake, []byte{1, 0, 0, 0x10, 0, 2, 1, 2})...)
+	stream = append(stream, tlv(tlvString, []byte("hello"))...)
+	stream = append(stream, tlv(tlvBinary, []byte{0xde, 0xad})...)
+	stream = append(stream, tlv(tlvStream, append([]byte{streamChunk}, "abc"...))...)
+	stream = append(stream, tlv(tlvStream, []byte{streamEnd})...)
+	stream = append(stream, 0x7f, 0, 0, 0, 1, 'x')
+
+	expected := `Handshake v1 types=[1 2] max=4096
+String (5 bytes) "hello"
+Binary (2 bytes) de ad
+Stream chunk (3 bytes) 61 62 63
+Stream end
+`
+	for _, size := range []int{1, 3, len(stream)} {
+		d := new(TLV)
+		actual := new(bytes.Buffer)
+		for p := stream; len(p) > 0; p = p[min(len(p), size):] {
+			d.Decode(actual, p[:min(len(p), size)])
+		}
+		out := actual.String()
+		if !strings.HasPrefix(out, expected) {
+			t.Errorf("chunks of %d:\nexpected:\n%s\nactual:\n%s", size, expected, out)
+		}
+		if !strings.Contains(out, "invalid frame: type 127") || !strings.Contains(out, "  7f 00 00 00 01") {
+			t.Errorf("chunks of %d: expected a hexdump of the invalid frame:\n%s", size, out)
+		}
+	}
+}
+
+func TestTFTP(t *testing.T) {
+	rrq, _ := tftp.ReadReq{Filename: "test.svg", Mode: "octet"}.MarshalBinary()
+	data, _ := (&tftp.Data{Payload: strings.NewReader("hello")}).MarshalBinary()
+	ack, _ := tftp.Ack(1).MarshalBinary()
+	errPkt, _ := tftp.Err{Error: tftp.ErrNotFound, Message: "missing"}.MarshalBinary()
+
+	testCases := []struct {
+		packet   []byte
+		expected string
+	}{
+		{rrq, "RRQ filename=\"test.svg\" mode=\"octet\"\n"},
+		{data, "DATA block=1 (5 bytes)\n"},
+		{ack, "ACK block=1\n"},
+		{errPkt, "ERROR code=1 message=\"missing\"\n"},
+		{[]byte{0, 9, 1}, "unknown opcode 9 (3 bytes)\n"},
+		{[]byte{0}, "short packet 00\n"},
+	}
+	for i, c := range testCases {
+		actual := new(bytes.Buffer)
+		TFTP{}.Decode(actual, c.packet)
+		if actual.String() != c.expected {
+			t.Errorf("%d: expected %q; actual %q", i, c.expected, actual)
+		}
+	}
+}
+
+func TestMonitorConn(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	out := new(bytes.Buffer)
+	m := &Monitor{
+		Logger: log.New(out, "", 0),
+		Decoder: func(net.Conn) Decoder {
+			d, _ := NewDecoder("tlv")
+			return d
+		},
+	}
+	conn := m.Conn(server, ToServer)
+
+	go func() {
+		_, _ = client.Write(tlv(tlvString, []byte("ping")))
+		_, _ = io.ReadFull(client, make([]byte, 9))
+		_ = client.Close()
+	}()
+	_, err := io.ReadFull(conn, make([]byte, 9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Write(tlv(tlvString, []byte("pong")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"client->server:\nString (4 bytes) \"ping\"\n",
+		"server->client:\nString (4 bytes) \"pong\"\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, out)
+		}
+	}
+
+	_, err = NewDecoder("pcap")
+	if err == nil {
+		t.Error("expected an error for an unknown decoder")
+	}
+}
@@ -0,0 +1,100 @@
+package monitor
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"networkProgram/ch3"
+	"sync"
+)
+
+// Monitor embeds a log.Logger meant for logging network traffic
+type Monitor struct {
+	*log.Logger
+
+	// Decoder, if set, picks how the traffic of a connection passed to
+	// Conn is shown, for instance by its port. It's called once for each
+	// direction and must return a new Decoder every time. A nil Decoder
+	// func, or a nil result, logs the data as it is.
+	Decoder func(conn net.Conn) Decoder
+}
+
+// Write implements the io.Writer interface
+func (m *Monitor) Write(p []byte) (int, error) {
+	err := m.Output(2, string(p))
+	if err != nil {
+		log.Println(err)
+	}
+	return len(p), nil
+}
+
+// Conn returns a connection that logs everything read from and written to
+// conn. Data read is labeled with direction read, data written with the
+// reverse direction.
+func (m *Monitor) Conn(conn net.Conn, read Direction) net.Conn {
+	return &monitoredConn{
+		Conn: conn,
+		m:    m,
+		read: m.stream(conn, read),
+		// 两个方向各自使用独立的解码器，分别保存未完成的帧
+		write: m.stream(conn, read.Reverse()),
+	}
+}
+
+func (m *Monitor) stream(conn net.Conn, dir Direction) *stream {
+	var d Decoder
+	if m.Decoder != nil {
+		d = m.Decoder(conn)
+	}
+	if d == nil {
+		d = Raw{}
+	}
+	return &stream{dir: dir, d: d, prefix: conn.RemoteAddr().String() + " " + dir.String()}
+}
+
+// stream is one direction of a monitored connection.
+type stream struct {
+	mu     sync.Mutex
+	dir    Direction
+	d      Decoder
+	prefix string
+	buf    bytes.Buffer
+}
+
+func (m *Monitor) log(s *stream, p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Reset()
+	s.d.Decode(&s.buf, p)
+	if s.buf.Len() == 0 {
+		return // a partial message
+	}
+	err := m.Output(3, s.prefix+":\n"+s.buf.String())
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+type monitoredConn struct {
+	net.Conn
+	m           *Monitor
+	read, write *stream
+}
+
+func (c *monitoredConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.m.log(c.read, p[:n])
+	}
+	return n, err
+}
+
+func (c *monitoredConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.m.log(c.write, p[:n])
+	}
+	return n, err
+}
+
+func (c *monitoredConn) CloseWrite() error { return ch3.CloseWrite(c.Conn) }
@@ -0,0 +1,227 @@
+package monitor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordingRoundTrip(t *testing.T) {
+	buf := new(bytes.Buffer)
+	rec, err := NewRecorder(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Record{
+		{Dir: ToServer, Data: []byte("GET / HTTP/1.0\r\n\r\n")},
+		{Dir: ToClient, Data: []byte("HTTP/1.0 200 OK\r\n")},
+		{Dir: ToClient, Data: []byte{0, 1, 2, 0xff}},
+	}
+	for _, r := range expected {
+		if err := rec.Record(r.Dir, r.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = rec.Record(ToServer, nil) // not recorded
+
+	actual, err := ReadAll(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d records; actual %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		if actual[i].Dir != expected[i].Dir || !bytes.Equal(actual[i].Data, expected[i].Data) {
+			t.Errorf("%d: expected %v %q; actual %v %q", i,
+				expected[i].Dir, expected[i].Data, actual[i].Dir, actual[i].Data)
+		}
+		if time.Since(actual[i].Time) > time.Minute {
+			t.Errorf("%d: unexpected time %v", i, actual[i].Time)
+		}
+	}
+}
+
+func TestRecordingLarge(t *testing.T) {
+	// 超过MaxRecordSize的数据被拆成多条记录
+	buf := new(bytes.Buffer)
+	rec, err := NewRecorder(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte{0xab}, MaxRecordSize+1)
+	if err = rec.Record(ToClient, data); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ReadAll(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual) != 2 {
+		t.Fatalf("expected 2 records; actual %d", len(actual))
+	}
+	if len(actual[0].Data) != MaxRecordSize || len(actual[1].Data) != 1 {
+		t.Errorf("expected records of %d and 1 bytes; actual %d and %d",
+			MaxRecordSize, len(actual[0].Data), len(actual[1].Data))
+	}
+	joined := append(actual[0].Data, actual[1].Data...)
+	if actual[1].Dir != ToClient || !bytes.Equal(joined, data) {
+		t.Error("expected the records to add up to the recorded data")
+	}
+}
+
+func TestReaderInvalid(t *testing.T) {
+	_, err := ReadAll(strings.NewReader("not a recording"))
+	if !errors.Is(err, ErrNotRecording) {
+		t.Errorf("expected ErrNotRecording; actual %v", err)
+	}
+
+	// 记录在数据中间被截断
+	buf := new(bytes.Buffer)
+	rec, _ := NewRecorder(buf)
+	_ = rec.Record(ToServer, []byte("truncated"))
+	_, err = ReadAll(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF; actual %v", err)
+	}
+}
+
+// serve runs handler for every connection accepted on a new listener.
+func serve(t *testing.T, handler func(net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handler(conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func echo(conn net.Conn) { _, _ = io.Copy(conn, conn) }
+
+func upper(conn net.Conn) {
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write(bytes.ToUpper(buf[:n]))
+	}
+}
+
+// recordSession records a client session with the echo server.
+func recordSession(t *testing.T, addr string, requests ...string) []Record {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	rec, _ := NewRecorder(buf)
+	c := NewConn(conn, rec, ToClient)
+	for _, req := range requests {
+		_, err = c.Write([]byte(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadFull(c, make([]byte, len(req)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = c.Close()
+
+	records, err := ReadAll(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) < 2*len(requests) || records[0].Dir != ToServer || records[1].Dir != ToClient {
+		t.Fatalf("unexpected recording %v", records)
+	}
+	return records
+}
+
+func TestReplay(t *testing.T) {
+	records := recordSession(t, serve(t, echo), "hello\n", "world\n")
+
+	testCases := []struct {
+		handler    func(net.Conn)
+		mismatches []Mismatch
+	}{
+		{echo, nil},
+		{upper, []Mismatch{
+			{Exchange: 0, Offset: 0, Expected: []byte("hello\n"), Actual: []byte("HELLO\n")},
+			{Exchange: 1, Offset: 0, Expected: []byte("world\n"), Actual: []byte("WORLD\n")},
+		}},
+		{func(conn net.Conn) { _, _ = conn.Write([]byte("hel")) }, []Mismatch{
+			{Exchange: 0, Offset: 3, Expected: []byte("hello\n"), Actual: []byte("hel")},
+			{Exchange: 1, Offset: 0, Expected: []byte("world\n"), Actual: nil},
+		}},
+	}
+
+	for i, c := range testCases {
+		conn, err := net.Dial("tcp", serve(t, c.handler))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := Replay(conn, records, 500*time.Millisecond)
+		_ = conn.Close()
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if len(actual) != len(c.mismatches) {
+			t.Errorf("%d: expected %d mismatches; actual %v", i, len(c.mismatches), actual)
+			continue
+		}
+		for j, m := range c.mismatches {
+			a := actual[j]
+			if a.Exchange != m.Exchange || a.Offset != m.Offset ||
+				!bytes.Equal(a.Expected, m.Expected) || !bytes.Equal(a.Actual, m.Actual) {
+				t.Errorf("%d: expected %v; actual %v", i, m, a)
+			}
+		}
+	}
+}
+
+func TestReplayExtraData(t *testing.T) {
+	records := []Record{
+		{Dir: ToServer, Data: []byte("ping")},
+		{Dir: ToClient, Data: []byte("pong")},
+	}
+	addr := serve(t, func(conn net.Conn) {
+		_, _ = io.ReadFull(conn, make([]byte, 4))
+		_, _ = conn.Write([]byte("pong and more"))
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	mismatches, err := Replay(conn, records, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || string(mismatches[0].Actual) != " and more" {
+		t.Fatalf("expected the extra data as a mismatch; actual %v", mismatches)
+	}
+}
@@ -0,0 +1,232 @@
+package monitor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"networkProgram/ch3"
+	"sync"
+	"time"
+)
+
+// A recording holds the traffic of one connection in both directions. It
+// starts with the 8 byte magic "NPREC01\n", followed by one record per
+// Read or Write on the connection:
+//
+//	time      int64, big endian: unix nanoseconds when the data was seen
+//	direction 1 byte: '>' client to server, '<' server to client
+//	length    uint32, big endian
+//	data      length bytes
+//
+// The format is meant to be easy to produce and parse, not compact.
+const magic = "NPREC01\n"
+
+// MaxRecordSize is the largest record Reader accepts.
+const MaxRecordSize = 16 << 20
+
+var (
+	ErrNotRecording   = errors.New("not a traffic recording")
+	ErrInvalidRecord  = errors.New("invalid record")
+	ErrRecordTooLarge = errors.New("record exceeds maximum size")
+)
+
+// Direction tells which way a record's data went.
+type Direction byte
+
+const (
+	ToServer Direction = '>'
+	ToClient Direction = '<'
+)
+
+func (d Direction) String() string {
+	switch d {
+	case ToServer:
+		return "client->server"
+	case ToClient:
+		return "server->client"
+	default:
+		return fmt.Sprintf("Direction(%q)", byte(d))
+	}
+}
+
+// Reverse returns the opposite direction.
+func (d Direction) Reverse() Direction {
+	if d == ToServer {
+		return ToClient
+	}
+	return ToServer
+}
+
+// Record is the data seen in one direction at one point in time.
+type Record struct {
+	Time time.Time
+	Dir  Direction
+	Data []byte
+}
+
+// Recorder writes records to a recording. It's safe for concurrent use,
+// so both directions of a connection can share one.
+type Recorder struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewRecorder writes the recording header to w and returns a Recorder
+// that appends records to it.
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	_, err := io.WriteString(w, magic)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{w: w}, nil
+}
+
+// Record appends p as a record in direction dir, split into records of at
+// most MaxRecordSize so Reader can read them back. Once a write fails,
+// Record keeps returning that error.
+func (r *Recorder) Record(dir Direction, p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+
+	var hdr [13]byte
+	binary.BigEndian.PutUint64(hdr[:8], uint64(time.Now().UnixNano()))
+	hdr[8] = byte(dir)
+	for len(p) > 0 && r.err == nil {
+		chunk := p[:min(len(p), MaxRecordSize)]
+		p = p[len(chunk):]
+		binary.BigEndian.PutUint32(hdr[9:], uint32(len(chunk)))
+		// 头部和数据必须连续写入，否则另一个方向的记录可能插在中间
+		_, r.err = r.w.Write(append(hdr[:], chunk...))
+	}
+	return r.err
+}
+
+// Writer returns an io.Writer that records everything written to it in
+// direction dir, for use with io.TeeReader and io.MultiWriter.
+func (r *Recorder) Writer(dir Direction) io.Writer {
+	return recordWriter{r: r, dir: dir}
+}
+
+type recordWriter struct {
+	r   *Recorder
+	dir Direction
+}
+
+func (w recordWriter) Write(p []byte) (int, error) {
+	err := w.r.Record(w.dir, p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Conn records the traffic of the connection it wraps. Data read from the
+// connection is recorded in direction read, data written to it in the
+// reverse direction: use ToServer on the server side of a connection and
+// ToClient on the client side. Recording failures don't affect the
+// connection.
+type Conn struct {
+	net.Conn
+	rec  *Recorder
+	read Direction
+}
+
+func NewConn(conn net.Conn, rec *Recorder, read Direction) *Conn {
+	return &Conn{Conn: conn, rec: rec, read: read}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		_ = c.rec.Record(c.read, p[:n])
+	}
+	return n, err
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		_ = c.rec.Record(c.read.Reverse(), p[:n])
+	}
+	return n, err
+}
+
+func (c *Conn) CloseWrite() error { return ch3.CloseWrite(c.Conn) }
+
+// Reader reads records from a recording.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader checks the recording header and returns a Reader positioned
+// at the first record.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	b := make([]byte, len(magic))
+	_, err := io.ReadFull(br, b)
+	if err != nil || string(b) != magic {
+		return nil, ErrNotRecording
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next record, or io.EOF after the last one. A recording
+// that ends partway through a record returns io.ErrUnexpectedEOF.
+func (r *Reader) Next() (Record, error) {
+	var hdr [13]byte
+	_, err := io.ReadFull(r.r, hdr[:])
+	if err != nil {
+		return Record{}, err
+	}
+
+	rec := Record{
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(hdr[:8]))),
+		Dir:  Direction(hdr[8]),
+	}
+	if rec.Dir != ToServer && rec.Dir != ToClient {
+		return Record{}, fmt.Errorf("%w: direction %q", ErrInvalidRecord, hdr[8])
+	}
+	size := binary.BigEndian.Uint32(hdr[9:])
+	if size > MaxRecordSize {
+		return Record{}, ErrRecordTooLarge
+	}
+
+	rec.Data = make([]byte, size)
+	_, err = io.ReadFull(r.r, rec.Data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	return rec, nil
+}
+
+// ReadAll returns every record in the recording read from r.
+func ReadAll(r io.Reader) ([]Record, error) {
+	rr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for {
+		rec, err := rr.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
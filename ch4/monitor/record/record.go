@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"networkProgram/ch4/monitor"
+	"networkProgram/ch4/proxy"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	listen   = flag.String("l", "127.0.0.1:8081", "listen address")
+	upstream = flag.String("u", "", "upstream address")
+	dir      = flag.String("d", ".", "directory for the recordings")
+	verbose  = flag.String("v", "", "also log the traffic: raw, hex, tlv or tftp")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s -u upstream [options]\n\n"+
+				"Proxy connections to upstream, recording each one to its own file.\n\n",
+			filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *upstream == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var m *monitor.Monitor
+	if *verbose != "" {
+		if _, err := monitor.NewDecoder(*verbose); err != nil {
+			log.Fatal(err)
+		}
+		m = &monitor.Monitor{
+			Logger: log.New(os.Stdout, "", log.Ltime|log.Lmicroseconds),
+			Decoder: func(net.Conn) monitor.Decoder {
+				d, _ := monitor.NewDecoder(*verbose)
+				return d
+			},
+		}
+	}
+
+	var n atomic.Int64
+	p := &proxy.Proxy{
+		Upstream:    *upstream,
+		DialTimeout: 5 * time.Second,
+		ErrorLog:    log.Default(),
+		// 在上游一侧记录，读到的数据是服务器发给客户端的
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			name := filepath.Join(*dir, fmt.Sprintf("%s-%d.rec",
+				time.Now().Format("20060102-150405"), n.Add(1)))
+			if m != nil {
+				conn = m.Conn(conn, monitor.ToClient)
+			}
+			return record(conn, name)
+		},
+		OnClose: func(s proxy.Stats) {
+			log.Printf("[%s] closed: sent %d bytes, received %d bytes", s.Client, s.Sent, s.Received)
+		},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	log.Printf("Recording %s -> %s into %s", *listen, *upstream, *dir)
+	err := p.ListenAndServe(ctx, *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// recordedConn closes its recording file along with the connection.
+type recordedConn struct {
+	*monitor.Conn
+	f *os.File
+}
+
+func record(conn net.Conn, name string) (net.Conn, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	rec, err := monitor.NewRecorder(f)
+	if err != nil {
+		_ = f.Close()
+		_ = conn.Close()
+		return nil, err
+	}
+	log.Printf("recording to %s", name)
+	return &recordedConn{Conn: monitor.NewConn(conn, rec, monitor.ToClient), f: f}, nil
+}
+
+func (c *recordedConn) Close() error {
+	err := c.Conn.Close()
+	_ = c.f.Close()
+	return err
+}
@@ -0,0 +1,132 @@
+package monitor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"networkProgram/ch3"
+	"os"
+	"time"
+)
+
+// Mismatch describes a server response that differs from the recording.
+type Mismatch struct {
+	Exchange int    // the index of the response in the recording, counting from 0
+	Offset   int    // the first byte that differs
+	Expected []byte // the recorded response
+	Actual   []byte // what the server sent instead
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("response %d differs at byte %d:\n  expected %q\n  actual   %q",
+		m.Exchange, m.Offset, excerpt(m.Expected, m.Offset), excerpt(m.Actual, m.Offset))
+}
+
+// excerpt returns up to 32 bytes of b around offset.
+func excerpt(b []byte, offset int) []byte {
+	start := max(offset-8, 0)
+	if start > len(b) {
+		return nil
+	}
+	return b[start:min(start+32, len(b))]
+}
+
+// exchange is consecutive data in one direction.
+type exchange struct {
+	dir  Direction
+	data []byte
+}
+
+func exchanges(records []Record) []exchange {
+	var ex []exchange
+	for _, r := range records {
+		if n := len(ex); n > 0 && ex[n-1].dir == r.Dir {
+			ex[n-1].data = append(ex[n-1].data, r.Data...)
+			continue
+		}
+		ex = append(ex, exchange{dir: r.Dir, data: append([]byte(nil), r.Data...)})
+	}
+	return ex
+}
+
+// Replay plays the client side of a recording over conn and compares what
+// the server sends back with the recorded responses. Consecutive records
+// in the same direction are merged, so the server may split its responses
+// differently than it did while recording.
+//
+// Replay waits up to timeout for each response. When the client is done,
+// Replay half-closes conn and reports any further data from the server as
+// a mismatch too. A server that sends less than expected, or closes the
+// connection early, results in mismatches for the missing responses
+// rather than an error; errors are returned only for failures to send.
+func Replay(conn net.Conn, records []Record, timeout time.Duration) ([]Mismatch, error) {
+	var mismatches []Mismatch
+	response := 0
+	closed := false // the server has closed the connection
+	for _, ex := range exchanges(records) {
+		if ex.dir == ToServer {
+			if closed {
+				continue
+			}
+			_, err := conn.Write(ex.data)
+			if err != nil {
+				return mismatches, fmt.Errorf("sending request: %w", err)
+			}
+			continue
+		}
+
+		var actual []byte
+		if !closed {
+			actual, closed = readFull(conn, len(ex.data), timeout)
+		}
+		if m, ok := compare(response, ex.data, actual); !ok {
+			mismatches = append(mismatches, m)
+		}
+		response++
+	}
+	if closed {
+		return mismatches, nil
+	}
+
+	// 服务器多发送的数据同样算作不一致
+	_ = ch3.CloseWrite(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	extra, _ := io.ReadAll(conn)
+	if len(extra) > 0 {
+		mismatches = append(mismatches, Mismatch{Exchange: response, Actual: extra})
+	}
+	return mismatches, nil
+}
+
+// readFull reads up to n bytes from conn, stopping early if the server
+// sends nothing for timeout. It reports whether the connection is gone.
+func readFull(conn net.Conn, n int, timeout time.Duration) ([]byte, bool) {
+	buf := make([]byte, n)
+	read := 0
+	for read < n {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		m, err := conn.Read(buf[read:])
+		read += m
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			// EOF或者连接被重置：服务器不会再发送任何数据
+			return buf[:read], true
+		}
+	}
+	return buf[:read], false
+}
+
+func compare(i int, expected, actual []byte) (Mismatch, bool) {
+	if bytes.Equal(expected, actual) {
+		return Mismatch{}, true
+	}
+	offset := 0
+	for offset < len(expected) && offset < len(actual) && expected[offset] == actual[offset] {
+		offset++
+	}
+	return Mismatch{Exchange: i, Offset: offset, Expected: expected, Actual: actual}, false
+}
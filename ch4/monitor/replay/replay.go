@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"networkProgram/ch4/monitor"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	server  = flag.String("s", "127.0.0.1:8080", "server address")
+	timeout = flag.Duration("t", 2*time.Second, "time to wait for each response")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [options] recording...\n\n"+
+				"Replay recorded client sessions against a server and report\n"+
+				"responses that differ from the recording.\n\n",
+			filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, name := range flag.Args() {
+		mismatches, err := replay(name)
+		if err != nil {
+			log.Printf("%s: %v", name, err)
+			failed = true
+			continue
+		}
+		if len(mismatches) == 0 {
+			fmt.Printf("%s: ok\n", name)
+			continue
+		}
+		failed = true
+		fmt.Printf("%s: %d mismatches\n", name, len(mismatches))
+		for _, m := range mismatches {
+			fmt.Println(m)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func replay(name string) ([]monitor.Mismatch, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	records, err := monitor.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", *server, *timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return monitor.Replay(conn, records, *timeout)
+}
@@ -0,0 +1,22 @@
+package ping
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Connect measures how long it takes to establish a connection to addr,
+// then closes the connection. The ping tool uses it to time TCP
+// handshakes and the proxy pool uses it for health checks.
+func Connect(ctx context.Context, network, addr string) (time.Duration, error) {
+	var d net.Dialer
+	start := time.Now()
+	c, err := d.DialContext(ctx, network, addr)
+	dur := time.Since(start)
+	if err != nil {
+		return dur, err
+	}
+	_ = c.Close()
+	return dur, nil
+}
@@ -0,0 +1,305 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"networkProgram/ch3"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrFaultReset     = errors.New("connection reset by fault injection")
+	ErrFaultTruncated = errors.New("response truncated by fault injection")
+)
+
+// Faults describes how a connection should misbehave. Unless noted
+// otherwise, faults apply to the data the proxy sends to the client, so
+// the client sees a misbehaving server.
+type Faults struct {
+	Latency       time.Duration // delay before every chunk of data
+	Bandwidth     int           // bytes per second; 0 means unlimited
+	ResetRate     float64       // chance, per chunk in either direction, of resetting the connection
+	CorruptRate   float64       // chance, per chunk, of flipping a random byte
+	TruncateAfter int64         // close the connection after this many bytes; 0 means never
+	Stall         bool          // accept new connections but never respond, nor connect upstream
+}
+
+// faultsJSON is the control API's view of Faults, with readable durations.
+type faultsJSON struct {
+	Latency       string  `json:"latency,omitempty"`
+	Bandwidth     int     `json:"bandwidth,omitempty"`
+	ResetRate     float64 `json:"reset_rate,omitempty"`
+	CorruptRate   float64 `json:"corrupt_rate,omitempty"`
+	TruncateAfter int64   `json:"truncate_after,omitempty"`
+	Stall         bool    `json:"stall,omitempty"`
+}
+
+func (f Faults) MarshalJSON() ([]byte, error) {
+	j := faultsJSON{
+		Bandwidth:     f.Bandwidth,
+		ResetRate:     f.ResetRate,
+		CorruptRate:   f.CorruptRate,
+		TruncateAfter: f.TruncateAfter,
+		Stall:         f.Stall,
+	}
+	if f.Latency > 0 {
+		j.Latency = f.Latency.String()
+	}
+	return json.Marshal(j)
+}
+
+func (f *Faults) UnmarshalJSON(b []byte) error {
+	var j faultsJSON
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+	var latency time.Duration
+	if j.Latency != "" {
+		latency, err = time.ParseDuration(j.Latency)
+		if err != nil {
+			return fmt.Errorf("latency: %w", err)
+		}
+	}
+	*f = Faults{
+		Latency:       latency,
+		Bandwidth:     j.Bandwidth,
+		ResetRate:     j.ResetRate,
+		CorruptRate:   j.CorruptRate,
+		TruncateAfter: j.TruncateAfter,
+		Stall:         j.Stall,
+	}
+	return f.Validate()
+}
+
+// Validate reports the first setting that is out of range.
+func (f Faults) Validate() error {
+	switch {
+	case f.Latency < 0:
+		return errors.New("latency must not be negative")
+	case f.Bandwidth < 0:
+		return errors.New("bandwidth must not be negative")
+	case f.ResetRate < 0 || f.ResetRate > 1:
+		return errors.New("reset rate must be between 0 and 1")
+	case f.CorruptRate < 0 || f.CorruptRate > 1:
+		return errors.New("corrupt rate must be between 0 and 1")
+	case f.TruncateAfter < 0:
+		return errors.New("truncate after must not be negative")
+	}
+	return nil
+}
+
+// FaultInjector holds the faults a Proxy applies to its connections: a
+// default for every client, and overrides for particular client IPs.
+// Changes take effect immediately, on open connections too, except for
+// Stall, which only affects new connections.
+//
+// FaultInjector is also an http.Handler for changing the faults at
+// runtime. Mount it under /faults/ on a local address:
+//
+//	GET    /faults/     the default faults and every override
+//	PUT    /faults/     replace the default faults with the JSON body
+//	PUT    /faults/IP   replace the faults for client IP
+//	DELETE /faults/IP   remove the override for client IP
+//	DELETE /faults/     clear the default faults
+//
+// Faults are written as JSON like
+// {"latency":"200ms","bandwidth":1024,"reset_rate":0.01}.
+type FaultInjector struct {
+	mu       sync.Mutex
+	defaults Faults
+	clients  map[string]Faults
+	rand     *rand.Rand
+}
+
+func NewFaultInjector(defaults Faults) *FaultInjector {
+	return &FaultInjector{
+		defaults: defaults,
+		clients:  make(map[string]Faults),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Set replaces the faults for the client IP, or the default faults if ip
+// is empty.
+func (f *FaultInjector) Set(ip string, faults Faults) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ip == "" {
+		f.defaults = faults
+		return
+	}
+	f.clients[ip] = faults
+}
+
+// Remove drops the override for the client IP.
+func (f *FaultInjector) Remove(ip string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.clients, ip)
+}
+
+// For returns the faults that apply to client.
+func (f *FaultInjector) For(client net.Addr) Faults {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if faults, ok := f.clients[hostOf(client)]; ok {
+		return faults
+	}
+	return f.defaults
+}
+
+// chance reports true with probability p.
+func (f *FaultInjector) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand.Float64() < p
+}
+
+func (f *FaultInjector) intn(n int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand.Intn(n)
+}
+
+// Conn wraps client, a connection the proxy accepted, so that the faults
+// for it are applied as data flows.
+func (f *FaultInjector) Conn(client net.Conn) net.Conn {
+	return &faultConn{Conn: client, f: f}
+}
+
+type faultConn struct {
+	net.Conn
+	f    *FaultInjector
+	sent int64 // bytes written to the client
+}
+
+func (c *faultConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && c.f.chance(c.f.For(c.RemoteAddr()).ResetRate) {
+		c.reset()
+		return 0, ErrFaultReset
+	}
+	return n, err
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	faults := c.f.For(c.RemoteAddr())
+	if faults.Latency > 0 {
+		time.Sleep(faults.Latency)
+	}
+	if c.f.chance(faults.ResetRate) {
+		c.reset()
+		return 0, ErrFaultReset
+	}
+	if len(p) > 0 && c.f.chance(faults.CorruptRate) {
+		// 不能修改调用方的缓冲区
+		p = append([]byte(nil), p...)
+		p[c.f.intn(len(p))] ^= 0xff
+	}
+
+	truncated := false
+	if faults.TruncateAfter > 0 && c.sent+int64(len(p)) >= faults.TruncateAfter {
+		p = p[:max(faults.TruncateAfter-c.sent, 0)]
+		truncated = true
+	}
+
+	n, err := c.throttledWrite(p, faults.Bandwidth)
+	c.sent += int64(n)
+	if err == nil && truncated {
+		_ = c.Conn.Close()
+		err = ErrFaultTruncated
+	}
+	return n, err
+}
+
+// throttledWrite writes p in slices of a tenth of a second's worth of
+// bandwidth, pausing after each one.
+func (c *faultConn) throttledWrite(p []byte, bandwidth int) (int, error) {
+	if bandwidth <= 0 {
+		return c.Conn.Write(p)
+	}
+	slice := max(bandwidth/10, 1)
+	written := 0
+	for written < len(p) {
+		end := min(written+slice, len(p))
+		n, err := c.Conn.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		time.Sleep(time.Duration(n) * time.Second / time.Duration(bandwidth))
+	}
+	return written, nil
+}
+
+// reset closes the connection with a TCP RST instead of a FIN.
+func (c *faultConn) reset() {
+	if tcp, ok := c.Conn.(*net.TCPConn); ok {
+		_ = tcp.SetLinger(0)
+	}
+	_ = c.Conn.Close()
+}
+
+func (c *faultConn) CloseWrite() error { return ch3.CloseWrite(c.Conn) }
+
+// stall reads and discards whatever client sends, never replying, until
+// the client goes away.
+func stall(client net.Conn) {
+	_, _ = io.Copy(io.Discard, client)
+	_ = client.Close()
+}
+
+func (f *FaultInjector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 路径的最后一段是客户端IP，为空时表示默认配置
+	ip := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if ip != "" && net.ParseIP(ip) == nil {
+		http.Error(w, "invalid client IP", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		f.mu.Lock()
+		b, err := json.Marshal(struct {
+			Default Faults            `json:"default"`
+			Clients map[string]Faults `json:"clients"`
+		}{f.defaults, f.clients})
+		f.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(append(b, '\n'))
+	case http.MethodPut:
+		var faults Faults
+		err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&faults)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.Set(ip, faults)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if ip == "" {
+			f.Set("", Faults{})
+		} else {
+			f.Remove(ip)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sendServer writes payload to every client and closes the connection,
+// counting the clients it accepted.
+func sendServer(t *testing.T, payload []byte) (net.Listener, *atomic.Int32) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := new(atomic.Int32)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func(c net.Conn) {
+				defer c.Close()
+				_, _ = c.Write(payload)
+			}(conn)
+		}
+	}()
+	return listener, accepted
+}
+
+// fetch connects through the proxy at addr and reads until the proxy
+// closes the connection or timeout passes.
+func fetch(t *testing.T, addr net.Addr, timeout time.Duration) ([]byte, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	return io.ReadAll(conn)
+}
+
+func TestFaults(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 200)
+	upstream, accepted := sendServer(t, payload)
+	defer upstream.Close()
+
+	faults := NewFaultInjector(Faults{})
+	addr, stop := startProxy(t, &Proxy{Upstream: upstream.Addr().String(), Faults: faults})
+	defer stop()
+
+	b, err := fetch(t, addr, time.Second)
+	if err != nil || !bytes.Equal(b, payload) {
+		t.Fatalf("without faults: %d bytes, %v", len(b), err)
+	}
+
+	faults.Set("", Faults{TruncateAfter: 100})
+	b, err = fetch(t, addr, time.Second)
+	if err != nil || !bytes.Equal(b, payload[:100]) {
+		t.Errorf("truncate: expected 100 bytes; actual %d bytes, %v", len(b), err)
+	}
+
+	faults.Set("", Faults{CorruptRate: 1})
+	b, err = fetch(t, addr, time.Second)
+	if err != nil || len(b) != len(payload) || bytes.Equal(b, payload) {
+		t.Errorf("corrupt: expected %d different bytes; actual %d bytes, %v", len(payload), len(b), err)
+	}
+
+	faults.Set("", Faults{Bandwidth: 10_000})
+	start := time.Now()
+	b, err = fetch(t, addr, 2*time.Second)
+	if err != nil || !bytes.Equal(b, payload) {
+		t.Errorf("bandwidth: %d bytes, %v", len(b), err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("bandwidth: 2000 bytes at 10000 B/s took only %s", elapsed)
+	}
+
+	faults.Set("", Faults{ResetRate: 1})
+	b, err = fetch(t, addr, time.Second)
+	if err == nil && len(b) > 0 {
+		t.Errorf("reset: expected a reset connection; actual %d bytes", len(b))
+	}
+
+	faults.Set("", Faults{})
+	faults.Set("127.0.0.1", Faults{Stall: true})
+	before := accepted.Load()
+	b, err = fetch(t, addr, 200*time.Millisecond)
+	if !errors.Is(err, os.ErrDeadlineExceeded) || len(b) > 0 {
+		t.Errorf("stall: expected a timeout; actual %d bytes, %v", len(b), err)
+	}
+	if accepted.Load() != before {
+		t.Error("stall: the proxy connected to the upstream")
+	}
+
+	faults.Remove("127.0.0.1")
+	b, err = fetch(t, addr, time.Second)
+	if err != nil || !bytes.Equal(b, payload) {
+		t.Errorf("after removing the override: %d bytes, %v", len(b), err)
+	}
+}
+
+func TestFaultsEmptyWrite(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() { _, _ = io.Copy(io.Discard, client) }()
+
+	// 空写入没有可以破坏的字节
+	conn := NewFaultInjector(Faults{CorruptRate: 1}).Conn(server)
+	if n, err := conn.Write(nil); n != 0 || err != nil {
+		t.Errorf("expected an empty write to succeed; actual %d, %v", n, err)
+	}
+}
+
+func TestFaultsValidate(t *testing.T) {
+	for _, f := range []Faults{{Latency: -1}, {Bandwidth: -1}, {ResetRate: 5}, {CorruptRate: -0.1}, {TruncateAfter: -1}} {
+		if err := f.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", f)
+		}
+	}
+	if err := (Faults{ResetRate: 0.5, Latency: time.Millisecond}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFaultControlAPI(t *testing.T) {
+	faults := NewFaultInjector(Faults{})
+	mux := http.NewServeMux()
+	mux.Handle("/faults/", faults)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	do := func(method, path, body string) int {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	testCases := []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPut, "/faults/", `{"latency":"250ms","bandwidth":512}`, http.StatusNoContent},
+		{http.MethodPut, "/faults/192.0.2.1", `{"stall":true}`, http.StatusNoContent},
+		{http.MethodPut, "/faults/not-an-ip", `{}`, http.StatusBadRequest},
+		{http.MethodPut, "/faults/", `{"latency":"soon"}`, http.StatusBadRequest},
+		{http.MethodPut, "/faults/", `{"reset_rate":2}`, http.StatusBadRequest},
+		{http.MethodPost, "/faults/", `{}`, http.StatusMethodNotAllowed},
+	}
+	for i, c := range testCases {
+		if status := do(c.method, c.path, c.body); status != c.status {
+			t.Errorf("%d: expected status %d; actual %d", i, c.status, status)
+		}
+	}
+
+	expected := Faults{Latency: 250 * time.Millisecond, Bandwidth: 512}
+	if actual := faults.For(&net.TCPAddr{IP: net.ParseIP("198.51.100.1")}); actual != expected {
+		t.Errorf("expected default %+v; actual %+v", expected, actual)
+	}
+	if !faults.For(&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}).Stall {
+		t.Error("expected the client override to stall")
+	}
+
+	resp, err := http.Get(ts.URL + "/faults/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var state struct {
+		Default Faults
+		Clients map[string]Faults
+	}
+	err = json.NewDecoder(resp.Body).Decode(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Default != expected || !state.Clients["192.0.2.1"].Stall {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	if do(http.MethodDelete, "/faults/192.0.2.1", "") != http.StatusNoContent ||
+		faults.For(&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}).Stall {
+		t.Error("expected the client override to be removed")
+	}
+}
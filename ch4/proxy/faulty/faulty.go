@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"networkProgram/ch4/proxy"
+	"os"
+	"os/signal"
+	"time"
+)
+
+var (
+	listen   = flag.String("l", "127.0.0.1:8081", "listen address")
+	upstream = flag.String("u", "", "upstream address")
+	control  = flag.String("c", "127.0.0.1:8099", "control API address")
+
+	latency  = flag.Duration("latency", 0, "delay before every chunk sent to the client")
+	rate     = flag.Int("bandwidth", 0, "bytes per second sent to the client; 0 means unlimited")
+	reset    = flag.Float64("reset", 0, "chance of resetting the connection per chunk")
+	corrupt  = flag.Float64("corrupt", 0, "chance of corrupting a byte per chunk")
+	truncate = flag.Int64("truncate", 0, "close connections after sending this many bytes")
+	stall    = flag.Bool("stall", false, "accept connections but never respond")
+)
+
+func main() {
+	flag.Parse()
+	if *upstream == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	initial := proxy.Faults{
+		Latency:       *latency,
+		Bandwidth:     *rate,
+		ResetRate:     *reset,
+		CorruptRate:   *corrupt,
+		TruncateAfter: *truncate,
+		Stall:         *stall,
+	}
+	if err := initial.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	faults := proxy.NewFaultInjector(initial)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// 控制接口只监听本地地址，运行时通过它调整故障
+	mux := http.NewServeMux()
+	mux.Handle("/faults/", faults)
+	srv := &http.Server{Addr: *control, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	defer srv.Close()
+
+	p := &proxy.Proxy{
+		Upstream:    *upstream,
+		DialTimeout: 5 * time.Second,
+		Faults:      faults,
+		ErrorLog:    log.Default(),
+	}
+	log.Printf("Proxying %s -> %s; control API on http://%s/faults/", *listen, *upstream, *control)
+	err := p.ListenAndServe(ctx, *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,13 @@
+package proxy
+
+import "strings"
+
+// MatchHost reports whether the host name matches pattern, ignoring case.
+// A pattern of the form "*.example.com" matches any subdomain of
+// example.com, but not example.com itself.
+func MatchHost(pattern, host string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok && strings.HasPrefix(suffix, ".") {
+		return len(host) > len(suffix) && strings.EqualFold(host[len(host)-len(suffix):], suffix)
+	}
+	return strings.EqualFold(pattern, host)
+}
@@ -0,0 +1,25 @@
+package proxy
+
+import "testing"
+
+func TestMatchHost(t *testing.T) {
+	testCases := []struct {
+		pattern, host string
+		match         bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com", true},
+		{"example.com", "www.example.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.Example.com", "a.b.example.COM", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "badexample.com", false},
+		{"*.example.com", "", false},
+		{"192.0.2.1", "192.0.2.1", true},
+	}
+	for _, c := range testCases {
+		if actual := MatchHost(c.pattern, c.host); actual != c.match {
+			t.Errorf("MatchHost(%q, %q) = %t; expected %t", c.pattern, c.host, actual, c.match)
+		}
+	}
+}
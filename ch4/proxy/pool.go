@@ -0,0 +1,330 @@
+package proxy
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"networkProgram/ch3"
+	"networkProgram/ch4/ping"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Strategy decides which upstream a Pool hands the next connection to.
+type Strategy int
+
+const (
+	RoundRobin       Strategy = iota // take turns
+	LeastConnections                 // the upstream with the fewest open connections
+	ConsistentHash                   // always the same upstream for a client IP
+)
+
+const replicas = 100 // points on the hash ring for each upstream
+
+var ErrNoUpstream = errors.New("no healthy upstream")
+
+// Pool spreads connections over a set of upstreams. An upstream is ejected
+// after MaxFails consecutive failed dials or health checks and is not
+// tried again until its backoff expires, doubling from BaseBackoff up to
+// MaxBackoff for every ejection in a row.
+type Pool struct {
+	MaxFails    int           // consecutive failures before ejection; defaults to 3
+	BaseBackoff time.Duration // first ejection period; defaults to 1s
+	MaxBackoff  time.Duration // longest ejection period; defaults to 1m
+
+	// DialFunc connects to an upstream. It defaults to the Dial and
+	// SockOpts of the Proxy using the Pool, or net.Dialer's DialContext
+	// when the Pool is used on its own.
+	DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	strategy  Strategy
+	mu        sync.Mutex
+	upstreams []*upstream
+	next      int         // the next round-robin position
+	ring      []ringPoint // sorted by hash
+}
+
+type upstream struct {
+	addr      string
+	active    int       // open connections
+	fails     int       // consecutive failures
+	ejections int       // consecutive ejections
+	retryAt   time.Time // when an ejected upstream may be tried again
+	ejected   bool
+	latency   time.Duration // the last successful health check
+}
+
+type ringPoint struct {
+	hash uint32
+	u    *upstream
+}
+
+// UpstreamStatus is a snapshot of one upstream in a Pool.
+type UpstreamStatus struct {
+	Addr    string
+	Active  int
+	Fails   int
+	Ejected bool
+	Latency time.Duration
+}
+
+func NewPool(strategy Strategy, addrs []string) *Pool {
+	p := &Pool{strategy: strategy}
+	for _, addr := range addrs {
+		u := &upstream{addr: addr}
+		p.upstreams = append(p.upstreams, u)
+		for i := 0; i < replicas; i++ {
+			p.ring = append(p.ring, ringPoint{hash: hash(addr + "#" + strconv.Itoa(i)), u: u})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	return p
+}
+
+// hash places keys on the ring the way ketama does, with the first four
+// bytes of their MD5 sum. FNV spreads keys that differ only in the last
+// byte, like neighbouring client IPs, too little for this.
+func hash(s string) uint32 {
+	sum := md5.Sum([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// Dial connects to an upstream chosen for client, moving on to the next
+// candidate if a dial fails. Closing the returned connection releases it
+// from the Pool's connection count. Dials canceled through ctx don't count
+// against the upstream, since the client or the caller gave up, not the
+// upstream.
+func (p *Pool) Dial(ctx context.Context, client net.Addr) (net.Conn, error) {
+	return p.dialWith(ctx, client, nil)
+}
+
+// dialWith is Dial using dial, if DialFunc isn't set, so a Proxy can pass
+// its own dialer.
+func (p *Pool) dialWith(ctx context.Context, client net.Addr,
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, error) {
+	if p.DialFunc != nil || dial == nil {
+		dial = p.dial
+	}
+	tried := make(map[*upstream]bool)
+	var errs []error
+	for {
+		p.mu.Lock()
+		u := p.pick(client, tried)
+		if u != nil {
+			u.active++
+		}
+		p.mu.Unlock()
+
+		if u == nil {
+			if len(errs) == 0 {
+				return nil, ErrNoUpstream
+			}
+			return nil, fmt.Errorf("%w: %w", ErrNoUpstream, errors.Join(errs...))
+		}
+		tried[u] = true
+
+		conn, err := dial(ctx, "tcp", u.addr)
+		if err != nil {
+			p.mu.Lock()
+			u.active--
+			p.mu.Unlock()
+			if !errors.Is(ctx.Err(), context.Canceled) {
+				p.report(u, err)
+			}
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				return nil, errors.Join(errs...)
+			}
+			continue
+		}
+		p.report(u, nil)
+		return &poolConn{Conn: conn, release: func() { p.release(u) }}, nil
+	}
+}
+
+func (p *Pool) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if p.DialFunc != nil {
+		return p.DialFunc(ctx, network, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// pick returns the best available upstream not in tried, or nil. An
+// ejected upstream is available again once its backoff has expired, and
+// the connection made to it decides whether it's readmitted.
+func (p *Pool) pick(client net.Addr, tried map[*upstream]bool) *upstream {
+	now := time.Now()
+	available := func(u *upstream) bool {
+		return !tried[u] && (!u.ejected || !now.Before(u.retryAt))
+	}
+
+	switch p.strategy {
+	case LeastConnections:
+		var best *upstream
+		for _, u := range p.upstreams {
+			if available(u) && (best == nil || u.active < best.active) {
+				best = u
+			}
+		}
+		return best
+	case ConsistentHash:
+		if len(p.ring) == 0 {
+			return nil
+		}
+		// 从客户端IP的哈希值开始，顺时针找到第一个可用的上游
+		h := hash(hostOf(client))
+		i := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+		for j := 0; j < len(p.ring); j++ {
+			if u := p.ring[(i+j)%len(p.ring)].u; available(u) {
+				return u
+			}
+		}
+		return nil
+	default:
+		for j := 0; j < len(p.upstreams); j++ {
+			u := p.upstreams[(p.next+j)%len(p.upstreams)]
+			if available(u) {
+				p.next = (p.next + j + 1) % len(p.upstreams)
+				return u
+			}
+		}
+		return nil
+	}
+}
+
+func hostOf(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// report records the outcome of a dial or health check.
+func (p *Pool) report(u *upstream, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		u.fails = 0
+		u.ejections = 0
+		u.ejected = false
+		return
+	}
+
+	u.fails++
+	maxFails := p.MaxFails
+	if maxFails <= 0 {
+		maxFails = 3
+	}
+	// 已经被剔除的上游在重试失败后立即延长剔除时间
+	if u.ejected || u.fails >= maxFails {
+		u.ejected = true
+		u.ejections++
+		u.retryAt = time.Now().Add(p.backoff(u.ejections))
+	}
+}
+
+func (p *Pool) backoff(ejections int) time.Duration {
+	base, max := p.BaseBackoff, p.MaxBackoff
+	if base <= 0 {
+		base = time.Second
+	}
+	if max <= 0 {
+		max = time.Minute
+	}
+	return ch3.Backoff{Initial: base, Max: max}.Delay(ejections)
+}
+
+func (p *Pool) release(u *upstream) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u.active--
+}
+
+// HealthCheck connects to every upstream each interval until ctx is
+// canceled, counting failures the same way as failed dials do. Ejected
+// upstreams are only checked once their backoff expires, and a successful
+// check readmits them.
+func (p *Pool) HealthCheck(ctx context.Context, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.check(ctx, timeout)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pool) check(ctx context.Context, timeout time.Duration) {
+	now := time.Now()
+	var wg sync.WaitGroup
+	p.mu.Lock()
+	for _, u := range p.upstreams {
+		if u.ejected && now.Before(u.retryAt) {
+			continue
+		}
+		wg.Add(1)
+		go func(u *upstream) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			latency, err := ping.Connect(checkCtx, "tcp", u.addr)
+			if ctx.Err() != nil {
+				return // the pool is shutting down, not the upstream
+			}
+			p.report(u, err)
+			if err == nil {
+				p.mu.Lock()
+				u.latency = latency
+				p.mu.Unlock()
+			}
+		}(u)
+	}
+	p.mu.Unlock()
+	wg.Wait()
+}
+
+// Status returns a snapshot of every upstream in the order given to NewPool.
+func (p *Pool) Status() []UpstreamStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make([]UpstreamStatus, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		status = append(status, UpstreamStatus{
+			Addr:    u.addr,
+			Active:  u.active,
+			Fails:   u.fails,
+			Ejected: u.ejected,
+			Latency: u.latency,
+		})
+	}
+	return status
+}
+
+// poolConn gives its upstream back to the Pool when closed.
+type poolConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *poolConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// CloseWrite keeps half-close working through Splice.
+func (c *poolConn) CloseWrite() error { return ch3.CloseWrite(c.Conn) }
@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// upstreams starts n listeners that accept connections and hold them open
+// until the client closes them.
+func upstreams(t *testing.T, n int) ([]net.Listener, []string) {
+	t.Helper()
+	var listeners []net.Listener
+	var addrs []string
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go func(c net.Conn) {
+					defer c.Close()
+					_, _ = c.Read(make([]byte, 1))
+				}(conn)
+			}
+		}()
+		listeners = append(listeners, l)
+		addrs = append(addrs, l.Addr().String())
+	}
+	t.Cleanup(func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	})
+	return listeners, addrs
+}
+
+func clientAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	_, addrs := upstreams(t, 3)
+	p := NewPool(RoundRobin, addrs)
+
+	for i := 0; i < 6; i++ {
+		conn, err := p.Dial(context.Background(), clientAddr("192.0.2.1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual, expected := conn.RemoteAddr().String(), addrs[i%3]; actual != expected {
+			t.Errorf("%d: expected %s; actual %s", i, expected, actual)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestPoolLeastConnections(t *testing.T) {
+	_, addrs := upstreams(t, 2)
+	p := NewPool(LeastConnections, addrs)
+
+	first, err := p.Dial(context.Background(), clientAddr("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 第一个上游还有一个打开的连接，新的连接应该交给第二个上游
+	second, err := p.Dial(context.Background(), clientAddr("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.RemoteAddr().String() == second.RemoteAddr().String() {
+		t.Fatalf("both connections went to %s", first.RemoteAddr())
+	}
+
+	_ = first.Close()
+	_ = first.Close() // closing twice must not release twice
+	third, err := p.Dial(context.Background(), clientAddr("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	if third.RemoteAddr().String() != first.RemoteAddr().String() {
+		t.Fatalf("expected %s; actual %s", first.RemoteAddr(), third.RemoteAddr())
+	}
+	if s := p.Status(); s[0].Active+s[1].Active != 2 {
+		t.Fatalf("expected 2 active connections; actual %+v", s)
+	}
+	_ = second.Close()
+}
+
+func TestPoolConsistentHash(t *testing.T) {
+	_, addrs := upstreams(t, 3)
+	p := NewPool(ConsistentHash, addrs)
+
+	chosen := make(map[string]bool)
+	for i := 1; i <= 20; i++ {
+		ip := fmt.Sprintf("192.0.2.%d", i)
+		var first string
+		for i := 0; i < 3; i++ {
+			conn, err := p.Dial(context.Background(), clientAddr(ip))
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := conn.RemoteAddr().String()
+			_ = conn.Close()
+			if first == "" {
+				first = addr
+			} else if addr != first {
+				t.Fatalf("%s: expected %s; actual %s", ip, first, addr)
+			}
+		}
+		chosen[first] = true
+	}
+	if len(chosen) < 2 {
+		t.Error("expected clients to be spread over more than one upstream")
+	}
+}
+
+func TestPoolEjection(t *testing.T) {
+	listeners, addrs := upstreams(t, 2)
+	_ = listeners[0].Close() // the first upstream is down
+
+	p := NewPool(RoundRobin, addrs)
+	p.MaxFails = 2
+	p.BaseBackoff = 200 * time.Millisecond
+
+	for i := 0; i < 4; i++ {
+		conn, err := p.Dial(context.Background(), clientAddr("192.0.2.1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn.RemoteAddr().String() != addrs[1] {
+			t.Fatalf("%d: expected failover to %s; actual %s", i, addrs[1], conn.RemoteAddr())
+		}
+		_ = conn.Close()
+	}
+	if s := p.Status()[0]; !s.Ejected || s.Fails != 2 {
+		t.Fatalf("expected the first upstream to be ejected; actual %+v", s)
+	}
+
+	// 上游恢复后，健康检查要等到退避时间过去才会重新接纳它
+	l, err := net.Listen("tcp", addrs[0])
+	if err != nil {
+		t.Skipf("cannot rebind %s: %v", addrs[0], err)
+	}
+	defer l.Close()
+
+	p.check(context.Background(), time.Second)
+	if !p.Status()[0].Ejected {
+		t.Fatal("upstream readmitted before its backoff expired")
+	}
+	time.Sleep(250 * time.Millisecond)
+	p.check(context.Background(), time.Second)
+	if s := p.Status()[0]; s.Ejected || s.Latency == 0 {
+		t.Fatalf("expected the first upstream to be readmitted; actual %+v", s)
+	}
+}
+
+func TestPoolDialCanceled(t *testing.T) {
+	_, addrs := upstreams(t, 1)
+	p := NewPool(RoundRobin, addrs)
+	p.MaxFails = 1
+	p.DialFunc = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	// 客户端离开或代理关闭导致的拨号失败不应剔除健康的上游
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := p.Dial(ctx, clientAddr("192.0.2.1")); err == nil {
+		t.Fatal("expected the canceled dial to fail")
+	}
+	if s := p.Status()[0]; s.Ejected || s.Fails != 0 {
+		t.Fatalf("expected the upstream to stay healthy; actual %+v", s)
+	}
+
+	// 拨号超时仍然算作上游的失败
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.Dial(ctx, clientAddr("192.0.2.1")); err == nil {
+		t.Fatal("expected the dial to time out")
+	}
+	if s := p.Status()[0]; !s.Ejected {
+		t.Fatalf("expected the upstream to be ejected; actual %+v", s)
+	}
+}
+
+func TestProxyPool(t *testing.T) {
+	upstream := halfCloseServer(t)
+	defer upstream.Close()
+
+	closed := make(chan Stats, 1)
+	var dialed atomic.Int32
+	p := &Proxy{
+		Pool:    NewPool(RoundRobin, []string{upstream.Addr().String()}),
+		OnClose: func(s Stats) { closed <- s },
+		// 没有设置Pool.DialFunc时，连接池也使用代理自己的拨号方式
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed.Add(1)
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	addr, stop := startProxy(t, p)
+	defer stop()
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = conn.Write([]byte("hello"))
+	_ = conn.(*net.TCPConn).CloseWrite()
+	buf := make([]byte, 64)
+	n, _ := conn.Read(buf)
+	_ = conn.Close()
+	if string(buf[:n]) != "received 5 bytes" {
+		t.Fatalf("unexpected reply %q", buf[:n])
+	}
+	if s := <-closed; s.Err != nil {
+		t.Fatal(s.Err)
+	}
+	if n := dialed.Load(); n != 1 {
+		t.Errorf("expected the pool to use the proxy's Dial once; actual %d", n)
+	}
+	if active := p.Pool.Status()[0].Active; active != 0 {
+		t.Fatalf("expected the connection to be released; actual %d active", active)
+	}
+}
@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"networkProgram/ch3"
+	"networkProgram/ch4/proxyproto"
+	"sync"
+	"time"
+)
+
+// Stats describes one proxied connection.
+type Stats struct {
+	Client   net.Addr
+	Upstream net.Addr
+	Start    time.Time
+	Duration time.Duration // how long the connection has been, or was, open
+	Sent     int64         // bytes copied from the client to the upstream
+	Received int64         // bytes copied from the upstream to the client
+	Err      error         // why the connection ended, nil for a clean close
+}
+
+// Proxy accepts client connections and relays each one to Upstream, or to
+// one of the upstreams in Pool if set.
+type Proxy struct {
+	Upstream    string        // the address dialed for every client
+	Pool        *Pool         // spreads clients over several upstreams instead
+	DialTimeout time.Duration // the time allowed to connect to Upstream; 0 means no limit
+	IdleTimeout time.Duration // close connections idle in both directions this long; 0 means never
+
+	// ProxyProtocol, if 1 or 2, sends a PROXY protocol header of that
+	// version to the upstream so it can see the client's address.
+	ProxyProtocol int
+
+	// Faults, if set, makes connections misbehave on purpose.
+	Faults *FaultInjector
+
+	// Dial connects to the upstream, or to Pool's upstreams unless the
+	// Pool has a DialFunc. It defaults to SockOpts' DialContext.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// SockOpts are set on the listener ListenAndServe binds, the client
+	// connections it accepts and the upstream connections dialed without
+	// Dial or Pool.DialFunc.
+	SockOpts ch3.SockOpts
+
+	// OnClose, if set, is called with the final Stats of every connection.
+	OnClose func(Stats)
+
+	// ErrorLog, if set, receives messages about failed connections.
+	ErrorLog *log.Logger
+
+	mu     sync.Mutex
+	active map[*tunnel]struct{}
+}
+
+type tunnel struct {
+	client   net.Addr
+	upstream net.Addr
+	start    time.Time
+	sent     Counter
+	received Counter
+}
+
+func (t *tunnel) stats() Stats {
+	return Stats{
+		Client:   t.client,
+		Upstream: t.upstream,
+		Start:    t.start,
+		Duration: time.Since(t.start),
+		Sent:     t.sent.Load(),
+		Received: t.received.Load(),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections
+// until ctx is canceled.
+func (p *Proxy) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := p.SockOpts.Listen(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("binding to tcp %s: %w", addr, err)
+	}
+	return p.Serve(ctx, l)
+}
+
+// Serve accepts connections on l until ctx is canceled or Accept fails,
+// relaying each connection in its own goroutine. Serve closes l. Once it
+// returns, Serve waits for the connections it accepted to finish; cancel
+// ctx to make them end sooner.
+func (p *Proxy) Serve(ctx context.Context, l net.Listener) error {
+	if p.Upstream == "" && p.Pool == nil {
+		return errors.New("upstream address or pool is required")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.handle(ctx, conn)
+		}()
+	}
+}
+
+func (p *Proxy) handle(ctx context.Context, client net.Conn) {
+	t := &tunnel{client: client.RemoteAddr(), start: time.Now()}
+
+	if p.Faults != nil {
+		if p.Faults.For(client.RemoteAddr()).Stall {
+			stop := context.AfterFunc(ctx, func() { _ = client.Close() })
+			defer stop()
+			p.track(t)
+			stall(client)
+			p.untrack(t)
+			p.finish(t, nil)
+			return
+		}
+		client = p.Faults.Conn(client)
+	}
+
+	upstream, err := p.dial(ctx, client.RemoteAddr())
+	if err != nil {
+		_ = client.Close()
+		p.finish(t, fmt.Errorf("dialing upstream: %w", err))
+		return
+	}
+	t.upstream = upstream.RemoteAddr()
+
+	if p.ProxyProtocol != 0 {
+		_, err = proxyproto.NewHeader(p.ProxyProtocol, client).WriteTo(upstream)
+		if err != nil {
+			_ = client.Close()
+			_ = upstream.Close()
+			p.finish(t, fmt.Errorf("sending PROXY protocol header: %w", err))
+			return
+		}
+	}
+
+	p.track(t)
+	// ctx被取消时关闭连接，Splice随之返回
+	stop := context.AfterFunc(ctx, func() {
+		_ = client.Close()
+		_ = upstream.Close()
+	})
+	defer stop()
+
+	_, _, err = Splice(client, upstream, p.IdleTimeout, &t.sent, &t.received)
+	p.untrack(t)
+	p.finish(t, err)
+}
+
+func (p *Proxy) dial(ctx context.Context, client net.Addr) (net.Conn, error) {
+	if p.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.DialTimeout)
+		defer cancel()
+	}
+	dial := p.Dial
+	if dial == nil {
+		dial = p.SockOpts.DialContext
+	}
+	if p.Pool != nil {
+		return p.Pool.dialWith(ctx, client, dial)
+	}
+	return dial(ctx, "tcp", p.Upstream)
+}
+
+func (p *Proxy) finish(t *tunnel, err error) {
+	s := t.stats()
+	s.Err = err
+	if err != nil && p.ErrorLog != nil {
+		p.ErrorLog.Printf("[%s] %v", s.Client, err)
+	}
+	if p.OnClose != nil {
+		p.OnClose(s)
+	}
+}
+
+func (p *Proxy) track(t *tunnel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active == nil {
+		p.active = make(map[*tunnel]struct{})
+	}
+	p.active[t] = struct{}{}
+}
+
+func (p *Proxy) untrack(t *tunnel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.active, t)
+}
+
+// Active returns the Stats of the connections currently being relayed.
+func (p *Proxy) Active() []Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]Stats, 0, len(p.active))
+	for t := range p.active {
+		stats = append(stats, t.stats())
+	}
+	return stats
+}
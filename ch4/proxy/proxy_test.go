@@ -0,0 +1,250 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"networkProgram/ch3"
+	"testing"
+	"time"
+)
+
+// halfCloseServer reads until the client stops sending, then replies with
+// the number of bytes it received. It only works if the proxy passes the
+// client's half-close through instead of closing the whole connection.
+func halfCloseServer(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				n, err := io.Copy(io.Discard, c)
+				if err != nil {
+					return
+				}
+				_, _ = fmt.Fprintf(c, "received %d bytes", n)
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+func startProxy(t *testing.T, p *Proxy) (net.Addr, context.CancelFunc) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := p.Serve(ctx, listener); err != nil {
+			t.Error(err)
+		}
+	}()
+	return listener.Addr(), func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestProxyHalfClose(t *testing.T) {
+	upstream := halfCloseServer(t)
+	defer upstream.Close()
+
+	closed := make(chan Stats, 1)
+	p := &Proxy{
+		Upstream: upstream.Addr().String(),
+		OnClose:  func(s Stats) { closed <- s },
+	}
+	addr, stop := startProxy(t, p)
+	defer stop()
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	payload := bytes.Repeat([]byte("x"), 100_000)
+	_, err = conn.Write(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 告诉服务器我们已经发送完毕，但仍然等待它的回复
+	err = conn.(*net.TCPConn).CloseWrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("received %d bytes", len(payload))
+	if string(reply) != expected {
+		t.Fatalf("expected reply %q; actual %q", expected, reply)
+	}
+
+	s := <-closed
+	if s.Err != nil {
+		t.Error(s.Err)
+	}
+	if s.Sent != int64(len(payload)) || s.Received != int64(len(expected)) {
+		t.Errorf("unexpected byte counts: sent %d, received %d", s.Sent, s.Received)
+	}
+	t.Logf("%s -> %s: sent %d, received %d in %s",
+		s.Client, s.Upstream, s.Sent, s.Received, s.Duration)
+}
+
+func TestProxyIdleTimeout(t *testing.T) {
+	upstream := halfCloseServer(t)
+	defer upstream.Close()
+
+	closed := make(chan Stats, 1)
+	p := &Proxy{
+		Upstream:    upstream.Addr().String(),
+		IdleTimeout: 200 * time.Millisecond,
+		OnClose:     func(s Stats) { closed <- s },
+	}
+	addr, stop := startProxy(t, p)
+	defer stop()
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 持续发送数据的连接不应该被视为空闲
+	for i := 0; i < 5; i++ {
+		_, err = conn.Write([]byte("ping"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if n := len(p.Active()); n != 1 {
+		t.Fatalf("expected 1 active connection; actual %d", n)
+	}
+
+	select {
+	case s := <-closed:
+		if !isTimeout(s.Err) {
+			t.Fatalf("expected timeout; actual %v", s.Err)
+		}
+		if s.Sent != 20 {
+			t.Errorf("expected 20 bytes sent; actual %d", s.Sent)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle connection was not closed")
+	}
+	if n := len(p.Active()); n != 0 {
+		t.Fatalf("expected no active connections; actual %d", n)
+	}
+}
+
+func TestProxyDialError(t *testing.T) {
+	// 先监听再关闭，得到一个没有程序监听的地址
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Close()
+
+	closed := make(chan Stats, 1)
+	p := &Proxy{
+		Upstream: l.Addr().String(),
+		OnClose:  func(s Stats) { closed <- s },
+	}
+	addr, stop := startProxy(t, p)
+	defer stop()
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF; actual %v", err)
+	}
+	if s := <-closed; s.Err == nil {
+		t.Error("expected dial error")
+	}
+}
+
+func TestProxyCircuitBreaker(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Close()
+
+	closed := make(chan Stats, 1)
+	breaker := &ch3.CircuitBreaker{FailureThreshold: 2, CoolDown: time.Minute}
+	p := &Proxy{
+		Upstream: l.Addr().String(),
+		Dial:     breaker.Wrap(nil),
+		OnClose:  func(s Stats) { closed <- s },
+	}
+	addr, stop := startProxy(t, p)
+	defer stop()
+
+	// 两次拨号失败后断路器打开，之后的客户端不再等待拨号
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = conn.Read(make([]byte, 1))
+		_ = conn.Close()
+
+		s := <-closed
+		if open := errors.Is(s.Err, ch3.ErrCircuitOpen); open != (i == 2) {
+			t.Errorf("client %d: unexpected error %v", i, s.Err)
+		}
+	}
+	if state := breaker.State(p.Upstream); state != ch3.Open {
+		t.Errorf("expected an open circuit; actual %s", state)
+	}
+}
+
+func TestSpliceWithoutHalfClose(t *testing.T) {
+	a, client := net.Pipe()
+	b, upstream := net.Pipe()
+	defer client.Close()
+	defer upstream.Close()
+
+	// 管道不支持半关闭，客户端发送完毕后只能关闭两个连接
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := Splice(a, b, 0, nil, nil)
+		done <- err
+	}()
+
+	_ = client.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected a clean end; actual %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Splice kept waiting on a connection that can't be half-closed")
+	}
+	if _, err := upstream.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the upstream to be closed; actual %v", err)
+	}
+}
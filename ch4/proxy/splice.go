@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"networkProgram/ch3"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counter counts the bytes copied in one direction. It's safe to read
+// while the copy is in progress.
+type Counter struct {
+	n atomic.Int64
+}
+
+func (c *Counter) Load() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.n.Load()
+}
+
+// Splice copies data between a and b in both directions until both
+// directions finish, then closes both connections.
+//
+// When one side stops sending (its Read returns io.EOF), Splice half-closes
+// the other side with CloseWrite, if it supports it, and keeps copying the
+// opposite direction, so protocols that send a request, shut down writing
+// and then wait for the reply keep working through the proxy. Any other
+// error aborts both directions.
+//
+// If idle is greater than zero, Splice gives up once neither direction has
+// moved any data for that long. aToB and bToA, if not nil, are updated as
+// data flows. Splice returns the bytes copied in each direction.
+func Splice(a, b net.Conn, idle time.Duration, aToB, bToA *Counter) (int64, int64, error) {
+	if aToB == nil {
+		aToB = new(Counter)
+	}
+	if bToA == nil {
+		bToA = new(Counter)
+	}
+
+	var (
+		last     atomic.Int64 // unix nanoseconds of the last successful read
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		closeAll = func() {
+			_ = a.Close()
+			_ = b.Close()
+		}
+	)
+	last.Store(time.Now().UnixNano())
+
+	copyHalf := func(dst, src net.Conn, c *Counter) {
+		defer wg.Done()
+		err := copyIdle(dst, src, idle, &last, c)
+		if err != nil {
+			// 一个方向出错后另一个方向也没有意义了，关闭两个连接让它退出。
+			// 只保留第一个错误，另一个方向随后返回的错误只是连接被关闭的结果
+			once.Do(func() {
+				firstErr = err
+				closeAll()
+			})
+			return
+		}
+		// src已经发送完毕，把半关闭传递给dst，继续等待另一个方向。
+		// dst不支持半关闭时只能关闭两个连接
+		if ch3.CloseWrite(dst) != nil {
+			once.Do(closeAll)
+		}
+	}
+
+	wg.Add(2)
+	go copyHalf(b, a, aToB)
+	go copyHalf(a, b, bToA)
+	wg.Wait()
+	once.Do(closeAll)
+
+	return aToB.Load(), bToA.Load(), firstErr
+}
+
+// copyIdle copies from src to dst until src returns io.EOF. With idle set,
+// reads time out periodically so the copy can give up once last, shared by
+// both directions, is more than idle in the past.
+func copyIdle(dst, src net.Conn, idle time.Duration, last *atomic.Int64, c *Counter) error {
+	buf := make([]byte, 32*1024)
+	for {
+		if idle > 0 {
+			_ = src.SetReadDeadline(time.Now().Add(idle))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			last.Store(time.Now().UnixNano())
+			if idle > 0 {
+				_ = dst.SetWriteDeadline(time.Now().Add(idle))
+			}
+			w, wErr := dst.Write(buf[:n])
+			c.n.Add(int64(w))
+			if wErr != nil {
+				return wErr
+			}
+		}
+
+		switch {
+		case err == nil:
+		case err == io.EOF:
+			return nil
+		case idle > 0 && isTimeout(err) &&
+			time.Since(time.Unix(0, last.Load())) < idle:
+			// 这个方向空闲，但另一个方向最近还有数据，继续等待
+		default:
+			return err
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	var nErr net.Error
+	return errors.As(err, &nErr) && nErr.Timeout()
+}
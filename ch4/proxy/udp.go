@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"networkProgram/ch3"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxDatagram is the largest UDP datagram UDPForwarder relays.
+const maxDatagram = 64 << 10
+
+// UDPStats describes one UDP session.
+type UDPStats struct {
+	Client          net.Addr
+	Upstream        net.Addr // where the client's datagrams go now
+	Start           time.Time
+	LastActive      time.Time
+	PacketsSent     int64 // datagrams from the client to the upstream
+	BytesSent       int64
+	PacketsReceived int64 // datagrams from the upstream to the client
+	BytesReceived   int64
+}
+
+// UDPForwarder relays datagrams between clients and Upstream. Every client
+// address gets a session with its own upstream socket, so the upstream
+// can tell clients apart and its replies find their way back.
+//
+// The client's datagrams go to the address the upstream last replied
+// from, so protocols like TFTP, where the server answers from a new port,
+// work through the forwarder. Replies are only accepted from the
+// upstream's IP address.
+type UDPForwarder struct {
+	Upstream    string        // the address datagrams are forwarded to
+	IdleTimeout time.Duration // end sessions idle in both directions this long; defaults to 1 minute
+	MaxSessions int           // drop datagrams from new clients beyond this many sessions; 0 means no limit
+
+	// SockOpts are set on the socket ListenAndServe binds and on the
+	// sessions' upstream sockets. Only RecvBuffer, SendBuffer and
+	// ReusePort apply.
+	SockOpts ch3.SockOpts
+
+	// OnClose, if set, is called with the final stats of every session.
+	OnClose func(UDPStats)
+
+	// ErrorLog, if set, receives messages about failed sessions.
+	ErrorLog *log.Logger
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+}
+
+type udpSession struct {
+	client   net.Addr
+	conn     net.PacketConn // the session's upstream socket
+	start    time.Time
+	peer     atomic.Pointer[net.UDPAddr]
+	last     atomic.Int64 // unix nanoseconds
+	sentPkts atomic.Int64
+	sent     atomic.Int64
+	recvPkts atomic.Int64
+	recv     atomic.Int64
+}
+
+func (s *udpSession) stats() UDPStats {
+	return UDPStats{
+		Client:          s.client,
+		Upstream:        s.peer.Load(),
+		Start:           s.start,
+		LastActive:      time.Unix(0, s.last.Load()),
+		PacketsSent:     s.sentPkts.Load(),
+		BytesSent:       s.sent.Load(),
+		PacketsReceived: s.recvPkts.Load(),
+		BytesReceived:   s.recv.Load(),
+	}
+}
+
+// ListenAndServe listens on the UDP address addr and forwards datagrams
+// until ctx is canceled.
+func (f *UDPForwarder) ListenAndServe(ctx context.Context, addr string) error {
+	pc, err := f.SockOpts.ListenPacket(ctx, "udp", addr)
+	if err != nil {
+		return fmt.Errorf("binding to udp %s: %w", addr, err)
+	}
+	return f.Serve(ctx, pc)
+}
+
+// Serve reads datagrams from pc until ctx is canceled or a read fails,
+// forwarding each one through its client's session. Serve closes pc and
+// ends every session before it returns.
+func (f *UDPForwarder) Serve(ctx context.Context, pc net.PacketConn) error {
+	upstream, err := net.ResolveUDPAddr("udp", f.Upstream)
+	if err != nil {
+		_ = pc.Close()
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-ctx.Done()
+		_ = pc.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer func() {
+		// 读取失败时ctx可能还没取消，先结束会话再等待
+		cancel()
+		wg.Wait()
+	}()
+	buf := make([]byte, maxDatagram)
+	for {
+		n, client, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		s, err := f.session(ctx, &wg, pc, client, upstream)
+		if err != nil {
+			f.logf("[%s] %v", client, err)
+			continue
+		}
+		_, err = s.conn.WriteTo(buf[:n], s.peer.Load())
+		if err != nil {
+			f.logf("[%s] forwarding: %v", client, err)
+			continue
+		}
+		s.last.Store(time.Now().UnixNano())
+		s.sentPkts.Add(1)
+		s.sent.Add(int64(n))
+	}
+}
+
+var errTooManySessions = errors.New("too many sessions; dropping datagram")
+
+// session returns the client's session, starting one if necessary.
+func (f *UDPForwarder) session(ctx context.Context, wg *sync.WaitGroup, pc net.PacketConn,
+	client net.Addr, upstream *net.UDPAddr) (*udpSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.sessions[client.String()]; ok {
+		return s, nil
+	}
+	if f.MaxSessions > 0 && len(f.sessions) >= f.MaxSessions {
+		return nil, errTooManySessions
+	}
+
+	conn, err := f.SockOpts.ListenPacket(ctx, "udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	s := &udpSession{client: client, conn: conn, start: time.Now()}
+	s.peer.Store(upstream)
+	s.last.Store(s.start.UnixNano())
+	if f.sessions == nil {
+		f.sessions = make(map[string]*udpSession)
+	}
+	f.sessions[client.String()] = s
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		f.relay(ctx, pc, s, upstream.IP)
+	}()
+	return s, nil
+}
+
+// relay copies the upstream's replies to the client until the session is
+// idle for too long or ctx is canceled.
+func (f *UDPForwarder) relay(ctx context.Context, pc net.PacketConn, s *udpSession, upstreamIP net.IP) {
+	idle := f.IdleTimeout
+	if idle <= 0 {
+		idle = time.Minute
+	}
+	stop := context.AfterFunc(ctx, func() { _ = s.conn.Close() })
+	defer stop()
+	defer f.remove(s)
+
+	buf := make([]byte, maxDatagram)
+	for {
+		_ = s.conn.SetReadDeadline(time.Unix(0, s.last.Load()).Add(idle))
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if isTimeout(err) && time.Since(time.Unix(0, s.last.Load())) < idle {
+				continue // 客户端最近发送过数据，会话仍然活跃
+			}
+			return
+		}
+
+		addr, ok := from.(*net.UDPAddr)
+		if !ok || !addr.IP.Equal(upstreamIP) {
+			continue
+		}
+		// 上游可能从新的端口应答（比如TFTP），之后客户端的数据发往该端口
+		s.peer.Store(addr)
+		_, err = pc.WriteTo(buf[:n], s.client)
+		if err != nil {
+			f.logf("[%s] replying: %v", s.client, err)
+			continue
+		}
+		s.last.Store(time.Now().UnixNano())
+		s.recvPkts.Add(1)
+		s.recv.Add(int64(n))
+	}
+}
+
+func (f *UDPForwarder) remove(s *udpSession) {
+	_ = s.conn.Close()
+	f.mu.Lock()
+	delete(f.sessions, s.client.String())
+	f.mu.Unlock()
+	if f.OnClose != nil {
+		f.OnClose(s.stats())
+	}
+}
+
+// Sessions returns the stats of the open sessions.
+func (f *UDPForwarder) Sessions() []UDPStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := make([]UDPStats, 0, len(f.sessions))
+	for _, s := range f.sessions {
+		stats = append(stats, s.stats())
+	}
+	return stats
+}
+
+func (f *UDPForwarder) logf(format string, v ...any) {
+	if f.ErrorLog != nil {
+		f.ErrorLog.Printf(format, v...)
+	}
+}
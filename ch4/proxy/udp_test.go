@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func udpEcho(t *testing.T) net.PacketConn {
+	t.Helper()
+	echo, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = echo.Close() })
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+	return echo
+}
+
+func startUDPForwarder(t *testing.T, f *UDPForwarder) net.Addr {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := f.Serve(ctx, pc); err != nil {
+			t.Error(err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return pc.LocalAddr()
+}
+
+func udpRoundTrip(t *testing.T, client net.PacketConn, addr net.Addr, msg string) error {
+	t.Helper()
+	_, err := client.WriteTo([]byte(msg), addr)
+	if err != nil {
+		return err
+	}
+	_ = client.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	buf := make([]byte, 1024)
+	n, from, err := client.ReadFrom(buf)
+	if err != nil {
+		return err
+	}
+	if from.String() != addr.String() {
+		t.Errorf("reply from %s instead of %s", from, addr)
+	}
+	if !bytes.Equal(buf[:n], []byte(msg)) {
+		t.Errorf("expected %q; actual %q", msg, buf[:n])
+	}
+	return nil
+}
+
+func TestUDPForwarder(t *testing.T) {
+	echo := udpEcho(t)
+	closed := make(chan UDPStats, 2)
+	f := &UDPForwarder{
+		Upstream:    echo.LocalAddr().String(),
+		IdleTimeout: 200 * time.Millisecond,
+		MaxSessions: 2,
+		OnClose:     func(s UDPStats) { closed <- s },
+	}
+	addr := startUDPForwarder(t, f)
+
+	var clients []net.PacketConn
+	for i := 0; i < 3; i++ {
+		c, err := net.ListenPacket("udp", "127.0.0.1:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		clients = append(clients, c)
+	}
+
+	for i, msg := range []string{"one", "two", "three"} {
+		for _, c := range clients[:2] {
+			if err := udpRoundTrip(t, c, addr, msg); err != nil {
+				t.Fatalf("%d: %v", i, err)
+			}
+		}
+	}
+	if n := len(f.Sessions()); n != 2 {
+		t.Errorf("expected 2 sessions; actual %d", n)
+	}
+
+	// 会话数量已达上限，第三个客户端的数据被丢弃
+	if err := udpRoundTrip(t, clients[2], addr, "dropped"); !isTimeout(err) {
+		t.Errorf("expected the third client to time out; actual %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-closed:
+			if s.PacketsSent != 3 || s.PacketsReceived != 3 ||
+				s.BytesSent != 11 || s.BytesReceived != 11 {
+				t.Errorf("unexpected stats %+v", s)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("idle sessions did not expire")
+		}
+	}
+
+	// 空闲会话结束后，新的客户端可以建立会话
+	if err := udpRoundTrip(t, clients[2], addr, "now"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUDPForwarderFollowsReplyPort(t *testing.T) {
+	// 服务器像TFTP一样从新的端口应答，之后只在该端口接收数据
+	listen, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listen.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		_, client, err := listen.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		transfer, err := net.ListenPacket("udp", "127.0.0.1:")
+		if err != nil {
+			return
+		}
+		defer transfer.Close()
+		_, _ = transfer.WriteTo([]byte("from transfer port"), client)
+		n, from, err := transfer.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		_, _ = transfer.WriteTo(buf[:n], from)
+	}()
+
+	addr := startUDPForwarder(t, &UDPForwarder{Upstream: listen.LocalAddr().String()})
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	_, err = client.WriteTo([]byte("request"), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	if _, _, err = client.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := udpRoundTrip(t, client, addr, "ack"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingPacketConn fails reads once fail is closed.
+type failingPacketConn struct {
+	net.PacketConn
+	fail chan struct{}
+}
+
+func (c *failingPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	select {
+	case <-c.fail:
+		return 0, nil, errors.New("read failed")
+	default:
+		return n, addr, err
+	}
+}
+
+func TestUDPForwarderReadError(t *testing.T) {
+	echo := udpEcho(t)
+	f := &UDPForwarder{Upstream: echo.LocalAddr().String(), IdleTimeout: time.Hour}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fpc := &failingPacketConn{PacketConn: pc, fail: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() { done <- f.Serve(context.Background(), fpc) }()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err = udpRoundTrip(t, client, pc.LocalAddr(), "ping"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 读取失败后Serve应该结束会话并返回，而不是等会话空闲超时
+	close(fpc.fail)
+	_, _ = client.WriteTo([]byte("fail"), pc.LocalAddr())
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Error("expected the read error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve waited for the idle session")
+	}
+	if n := len(f.Sessions()); n != 0 {
+		t.Errorf("expected no sessions; actual %d", n)
+	}
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
-	"io"
 	"net"
+	tcpproxy "networkProgram/ch4/proxy"
 )
 
 func proxyConn(source, destination string) error {
@@ -19,12 +19,8 @@ func proxyConn(source, destination string) error {
 
 	defer connDestination.Close()
 
-	// connDestination replies to connSource
-	go func() {
-		_, _ = io.Copy(connSource, connDestination)
-	}()
-	// connSource  message to connDestination
-	_, err = io.Copy(connDestination, connSource)
+	// 双向复制数据：一个方向结束时把半关闭传递给另一端，等待两个方向都完成后才返回
+	_, _, err = tcpproxy.Splice(connSource, connDestination, 0, nil, nil)
 	return err
 
 }
@@ -0,0 +1,280 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	// v1Prefix starts every version 1 (text) header.
+	v1Prefix = []byte("PROXY ")
+	// v2Signature starts every version 2 (binary) header.
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	ErrNoHeader      = errors.New("no PROXY protocol header")
+	ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+)
+
+const (
+	v1MaxLength = 107 // the longest v1 header, including CRLF, the spec allows
+
+	v2Version = 0x20
+	v2Local   = 0x00 // the connection was made by the proxy itself, e.g. a health check
+	v2Proxy   = 0x01
+
+	v2Unspec = 0x00
+	v2TCP4   = 0x11
+	v2TCP6   = 0x21
+)
+
+// Header is a PROXY protocol header: the addresses of the connection the
+// proxy accepted, as seen by the proxy.
+type Header struct {
+	Version     int      // 1 for the text format, 2 for the binary format
+	Local       bool     // true if the proxy made the connection on its own behalf
+	Source      net.Addr // the original client; nil if unknown
+	Destination net.Addr // the address the client connected to; nil if unknown
+}
+
+// NewHeader returns a header describing conn, which a proxy accepted from
+// a client.
+func NewHeader(version int, conn net.Conn) *Header {
+	return &Header{Version: version, Source: conn.RemoteAddr(), Destination: conn.LocalAddr()}
+}
+
+// tcpAddrs returns the source and destination as TCP addresses of the
+// same family, or nil if they can't be expressed that way.
+func (h *Header) tcpAddrs() (src, dst *net.TCPAddr) {
+	src, ok1 := h.Source.(*net.TCPAddr)
+	dst, ok2 := h.Destination.(*net.TCPAddr)
+	if !ok1 || !ok2 || (src.IP.To4() == nil) != (dst.IP.To4() == nil) {
+		return nil, nil
+	}
+	return src, dst
+}
+
+func (h *Header) WriteTo(w io.Writer) (int64, error) {
+	var b []byte
+	var err error
+	switch h.Version {
+	case 1:
+		b = h.marshalV1()
+	case 2:
+		b, err = h.marshalV2()
+		if err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unsupported PROXY protocol version %d", h.Version)
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+func (h *Header) marshalV1() []byte {
+	src, dst := h.tcpAddrs()
+	if h.Local || src == nil {
+		// v1没有LOCAL命令，用UNKNOWN让接收方使用连接本身的地址
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	proto := "TCP4"
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	if srcIP == nil {
+		proto, srcIP, dstIP = "TCP6", src.IP.To16(), dst.IP.To16()
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n",
+		proto, srcIP, dstIP, src.Port, dst.Port))
+}
+
+func (h *Header) marshalV2() ([]byte, error) {
+	b := new(bytes.Buffer)
+	b.Write(v2Signature)
+
+	command := byte(v2Proxy)
+	if h.Local {
+		command = v2Local
+	}
+	b.WriteByte(v2Version | command)
+
+	src, dst := h.tcpAddrs()
+	switch {
+	case h.Local || src == nil:
+		b.WriteByte(v2Unspec)
+		err := binary.Write(b, binary.BigEndian, uint16(0))
+		if err != nil {
+			return nil, err
+		}
+	case src.IP.To4() != nil:
+		b.WriteByte(v2TCP4)
+		err := binary.Write(b, binary.BigEndian, uint16(4+4+2+2))
+		if err != nil {
+			return nil, err
+		}
+		b.Write(src.IP.To4())
+		b.Write(dst.IP.To4())
+		_ = binary.Write(b, binary.BigEndian, uint16(src.Port))
+		_ = binary.Write(b, binary.BigEndian, uint16(dst.Port))
+	default:
+		b.WriteByte(v2TCP6)
+		err := binary.Write(b, binary.BigEndian, uint16(16+16+2+2))
+		if err != nil {
+			return nil, err
+		}
+		b.Write(src.IP.To16())
+		b.Write(dst.IP.To16())
+		_ = binary.Write(b, binary.BigEndian, uint16(src.Port))
+		_ = binary.Write(b, binary.BigEndian, uint16(dst.Port))
+	}
+	return b.Bytes(), nil
+}
+
+// hasPrefix reports whether r's buffered input starts with prefix. It
+// peeks one byte at a time and stops at the first mismatch, so it never
+// waits for more bytes than a client that isn't sending the prefix sends.
+func hasPrefix(r *bufio.Reader, prefix []byte) (bool, error) {
+	for i := range prefix {
+		b, err := r.Peek(i + 1)
+		if err != nil {
+			return false, err
+		}
+		if b[i] != prefix[i] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Read reads a version 1 or version 2 header from r. It returns
+// ErrNoHeader, having consumed nothing, if r doesn't start with one.
+func Read(r *bufio.Reader) (*Header, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	switch b[0] {
+	case v1Prefix[0]:
+		ok, err = hasPrefix(r, v1Prefix)
+		if ok {
+			return readV1(r)
+		}
+	case v2Signature[0]:
+		ok, err = hasPrefix(r, v2Signature)
+		if ok {
+			return readV2(r)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrNoHeader
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: v1 header too long or missing CRLF", ErrInvalidHeader)
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	h := &Header{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, nil // the rest of the line is ignored
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, line)
+	}
+
+	src, err := parseAddr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseAddr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func parseAddr(proto, ip, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || (proto == "TCP4") != (addr.To4() != nil) {
+		return nil, fmt.Errorf("%w: bad %s address %q", ErrInvalidHeader, proto, ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad port %q", ErrInvalidHeader, port)
+	}
+	if proto == "TCP4" {
+		addr = addr.To4()
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	var fixed [16]byte // signature + version/command + family + length
+	_, err := io.ReadFull(r, fixed[:])
+	if err != nil {
+		return nil, err
+	}
+	if fixed[12]&0xF0 != v2Version {
+		return nil, fmt.Errorf("%w: version %d", ErrInvalidHeader, fixed[12]>>4)
+	}
+	command := fixed[12] & 0x0F
+	family := fixed[13]
+	length := binary.BigEndian.Uint16(fixed[14:])
+
+	// 地址之后可能还有TLV扩展字段，这里读取后忽略
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Header{Version: 2}
+	switch command {
+	case v2Local:
+		h.Local = true
+		return h, nil
+	case v2Proxy:
+	default:
+		return nil, fmt.Errorf("%w: command %d", ErrInvalidHeader, command)
+	}
+
+	switch family {
+	case v2TCP4:
+		if len(body) < 12 {
+			return nil, fmt.Errorf("%w: short TCP4 addresses", ErrInvalidHeader)
+		}
+		h.Source = &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}
+		h.Destination = &net.TCPAddr{IP: net.IP(body[4:8]), Port: int(binary.BigEndian.Uint16(body[10:12]))}
+	case v2TCP6:
+		if len(body) < 36 {
+			return nil, fmt.Errorf("%w: short TCP6 addresses", ErrInvalidHeader)
+		}
+		h.Source = &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}
+		h.Destination = &net.TCPAddr{IP: net.IP(body[16:32]), Port: int(binary.BigEndian.Uint16(body[34:36]))}
+	default:
+		// UDP和Unix地址族不是TCP代理关心的内容，当作地址未知处理
+	}
+	return h, nil
+}
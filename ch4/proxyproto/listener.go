@@ -0,0 +1,139 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"networkProgram/ch3"
+	"sync"
+	"time"
+)
+
+// Listener wraps a net.Listener whose clients connect through a proxy that
+// sends a PROXY protocol header. Accepted connections report the client
+// named in the header from RemoteAddr instead of the proxy's address.
+//
+// The header is read on the first call to Read, RemoteAddr or LocalAddr
+// rather than in Accept, so a slow client can't hold up the accept loop.
+// Servers like net/http call RemoteAddr in the connection's own goroutine.
+type Listener struct {
+	net.Listener
+
+	// ReadHeaderTimeout bounds the time spent reading the header. Zero
+	// means no limit. A read deadline set on the connection before the
+	// header is read still applies, and is restored afterwards.
+	ReadHeaderTimeout time.Duration
+
+	// Optional accepts connections that don't start with a header and
+	// reports their real addresses. Only enable it if clients can reach
+	// the listener without going through the proxy and you don't need
+	// to tell them apart, since anyone can send a header.
+	Optional bool
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{
+		Conn:     conn,
+		r:        bufio.NewReader(conn),
+		timeout:  l.ReadHeaderTimeout,
+		optional: l.Optional,
+	}, nil
+}
+
+// Conn is a connection accepted by Listener.
+type Conn struct {
+	net.Conn
+	r        *bufio.Reader
+	timeout  time.Duration
+	optional bool
+
+	once   sync.Once
+	header *Header
+	err    error
+
+	mu       sync.Mutex
+	deadline time.Time // the read deadline the caller set
+}
+
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.mu.Lock()
+			deadline := time.Now().Add(c.timeout)
+			if !c.deadline.IsZero() && c.deadline.Before(deadline) {
+				deadline = c.deadline
+			}
+			_ = c.Conn.SetReadDeadline(deadline)
+			c.mu.Unlock()
+			defer func() {
+				// 恢复调用者设置的截止时间，而不是清除它
+				c.mu.Lock()
+				_ = c.Conn.SetReadDeadline(c.deadline)
+				c.mu.Unlock()
+			}()
+		}
+		c.header, c.err = Read(c.r)
+		if errors.Is(c.err, ErrNoHeader) && c.optional {
+			c.err = nil
+		}
+		if c.err != nil {
+			// 头部无效时不能把连接交给上层当普通数据处理
+			_ = c.Conn.Close()
+		}
+	})
+}
+
+// Header returns the PROXY protocol header, reading it if necessary. It
+// returns nil and no error for an Optional listener's direct connections.
+func (c *Conn) Header() (*Header, error) {
+	c.readHeader()
+	return c.header, c.err
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// RemoteAddr returns the client's address from the header, or the
+// connection's own remote address if the header doesn't carry one.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to according to the
+// header, or the connection's own local address.
+func (c *Conn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *Conn) CloseWrite() error { return ch3.CloseWrite(c.Conn) }
@@ -0,0 +1,206 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	v4src := &net.TCPAddr{IP: net.ParseIP("192.0.2.10").To4(), Port: 51234}
+	v4dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.1").To4(), Port: 443}
+	v6src := &net.TCPAddr{IP: net.ParseIP("2001:db8::10"), Port: 51234}
+	v6dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+
+	testCases := []struct {
+		header Header
+		wire   string // expected v1 encoding, if any
+	}{
+		{Header{Version: 1, Source: v4src, Destination: v4dst},
+			"PROXY TCP4 192.0.2.10 198.51.100.1 51234 443\r\n"},
+		{Header{Version: 1, Source: v6src, Destination: v6dst},
+			"PROXY TCP6 2001:db8::10 2001:db8::1 51234 443\r\n"},
+		{Header{Version: 1}, "PROXY UNKNOWN\r\n"},
+		{Header{Version: 2, Source: v4src, Destination: v4dst}, ""},
+		{Header{Version: 2, Source: v6src, Destination: v6dst}, ""},
+		{Header{Version: 2, Local: true}, ""},
+	}
+
+	for i, c := range testCases {
+		buf := new(bytes.Buffer)
+		_, err := c.header.WriteTo(buf)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if c.wire != "" && buf.String() != c.wire {
+			t.Errorf("%d: expected %q; actual %q", i, c.wire, buf)
+		}
+
+		buf.WriteString("payload")
+		r := bufio.NewReader(buf)
+		actual, err := Read(r)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !reflect.DeepEqual(&c.header, actual) {
+			t.Errorf("%d: expected %+v; actual %+v", i, c.header, actual)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "payload" {
+			t.Errorf("%d: header parsing consumed data: %q", i, rest)
+		}
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected error
+	}{
+		{"GET / HTTP/1.1\r\n", ErrNoHeader},
+		{"PING", ErrNoHeader},
+		{"PROXY TCP4 192.0.2.1\r\n", ErrInvalidHeader},
+		{"PROXY TCP4 2001:db8::1 192.0.2.1 1 2\r\n", ErrInvalidHeader},
+		{"PROXY TCP4 192.0.2.1 192.0.2.2 1 70000\r\n", ErrInvalidHeader},
+		{"PROXY " + strings.Repeat("A", 200), ErrInvalidHeader},
+		{string(v2Signature) + "\x31\x11\x00\x00", ErrInvalidHeader}, // version 3
+	}
+	for i, c := range testCases {
+		_, err := Read(bufio.NewReader(strings.NewReader(c.input)))
+		if !errors.Is(err, c.expected) {
+			t.Errorf("%d: expected %v; actual %v", i, c.expected, err)
+		}
+	}
+}
+
+func TestListenerHTTP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, r.RemoteAddr)
+		}),
+	}
+	go func() { _ = srv.Serve(&Listener{Listener: l, ReadHeaderTimeout: time.Second}) }()
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	h := &Header{
+		Version:     2,
+		Source:      &net.TCPAddr{IP: net.ParseIP("203.0.113.7").To4(), Port: 4242},
+		Destination: &net.TCPAddr{IP: net.ParseIP("127.0.0.1").To4(), Port: 80},
+	}
+	if _, err := h.WriteTo(conn); err != nil {
+		t.Fatal(err)
+	}
+	_, err = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if string(b) != "203.0.113.7:4242" {
+		t.Fatalf("expected the client address from the header; actual %q", b)
+	}
+}
+
+func TestListenerRequired(t *testing.T) {
+	for _, optional := range []bool{false, true} {
+		l, err := net.Listen("tcp", "127.0.0.1:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pl := &Listener{Listener: l, Optional: optional}
+
+		go func() {
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = conn.Write([]byte("ping"))
+			_, _ = io.Copy(io.Discard, conn)
+		}()
+
+		conn, err := pl.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		switch {
+		case optional && err != nil:
+			t.Errorf("optional: %v", err)
+		case optional && conn.RemoteAddr().String() == l.Addr().String():
+			t.Errorf("optional: unexpected remote address %s", conn.RemoteAddr())
+		case !optional && !errors.Is(err, ErrNoHeader):
+			t.Errorf("required: expected ErrNoHeader; actual %v", err)
+		}
+		_ = conn.Close()
+		_ = l.Close()
+	}
+}
+
+func TestListenerKeepsDeadline(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	pl := &Listener{Listener: l, ReadHeaderTimeout: time.Minute}
+
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = NewHeader(1, conn).WriteTo(conn)
+		_, _ = io.Copy(io.Discard, conn) // 发送头部后不再发送数据
+	}()
+
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 在读取头部之前设置的截止时间在读取头部之后仍然有效
+	err = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		var nErr net.Error
+		if !errors.As(err, &nErr) || !nErr.Timeout() {
+			t.Errorf("expected a timeout; actual %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read deadline was cleared after reading the header")
+	}
+}
@@ -0,0 +1,180 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Client is a minimal SOCKS5 client, enough to use the CONNECT and UDP
+// ASSOCIATE commands through a Server.
+type Client struct {
+	Proxy    string // the server's address
+	Username string // authenticate with these if set
+	Password string
+}
+
+// handshake connects to the server, authenticates and sends a request. It
+// returns the connection and the address from the server's reply.
+func (c *Client) handshake(ctx context.Context, cmd byte, dst Addr) (net.Conn, Addr, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.Proxy)
+	if err != nil {
+		return nil, Addr{}, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+
+	bnd, err := c.request(conn, cmd, dst)
+	if err != nil {
+		_ = conn.Close()
+		return nil, Addr{}, err
+	}
+	return conn, bnd, nil
+}
+
+// request reads the server's replies straight from conn, without a
+// bufio.Reader, so no data the destination sends right after the final
+// reply gets lost in a buffer.
+func (c *Client) request(conn net.Conn, cmd byte, dst Addr) (Addr, error) {
+	method := byte(methodNone)
+	if c.Username != "" {
+		method = methodPassword
+	}
+	_, err := conn.Write([]byte{version, 1, method})
+	if err != nil {
+		return Addr{}, err
+	}
+	var reply [2]byte
+	_, err = io.ReadFull(conn, reply[:])
+	if err != nil {
+		return Addr{}, err
+	}
+	if reply[0] != version {
+		return Addr{}, ErrVersion
+	}
+	if reply[1] != method {
+		return Addr{}, ErrNoMethod
+	}
+
+	if method == methodPassword {
+		if len(c.Username) > 255 || len(c.Password) > 255 {
+			return Addr{}, errors.New("username or password too long")
+		}
+		b := append([]byte{authVersion, byte(len(c.Username))}, c.Username...)
+		b = append(append(b, byte(len(c.Password))), c.Password...)
+		_, err = conn.Write(b)
+		if err != nil {
+			return Addr{}, err
+		}
+		_, err = io.ReadFull(conn, reply[:])
+		if err != nil {
+			return Addr{}, err
+		}
+		if reply[1] != 0 {
+			return Addr{}, ErrAuthFailed
+		}
+	}
+
+	_, err = conn.Write(appendAddr([]byte{version, cmd, 0}, dst))
+	if err != nil {
+		return Addr{}, err
+	}
+	var hdr [3]byte
+	_, err = io.ReadFull(conn, hdr[:])
+	if err != nil {
+		return Addr{}, err
+	}
+	if hdr[1] != byte(replySucceeded) {
+		return Addr{}, ReplyError(hdr[1])
+	}
+	return readAddr(conn)
+}
+
+// DialContext connects to addr, a "host:port" address, through the server.
+// Only the tcp network is supported.
+func (c *Client) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+	dst, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := c.handshake(ctx, cmdConnect, dst)
+	return conn, err
+}
+
+// ListenPacket sets up a UDP association with the server and returns a
+// net.PacketConn that sends datagrams through it.
+func (c *Client) ListenPacket(ctx context.Context) (*PacketConn, error) {
+	conn, relay, err := c.handshake(ctx, cmdUDPAssociate, Addr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, err
+	}
+	if relay.IP == nil || relay.IP.IsUnspecified() {
+		// 服务器没有给出地址时，中继和服务器在同一个主机上
+		host, _, _ := net.SplitHostPort(c.Proxy)
+		relay.IP = net.ParseIP(host)
+	}
+	udp, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: relay.IP, Port: relay.Port})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &PacketConn{UDPConn: udp, control: conn}, nil
+}
+
+// PacketConn sends and receives datagrams through a SOCKS5 UDP relay. The
+// association lasts until Close.
+type PacketConn struct {
+	*net.UDPConn
+	control net.Conn
+}
+
+// WriteTo sends p to addr through the relay.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	b := appendAddr([]byte{0, 0, 0}, addrFromNet(addr))
+	_, err := c.UDPConn.Write(append(b, p...))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFrom reads a datagram relayed from addr.
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, err := c.UDPConn.Read(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n < 4 || buf[2] != 0 {
+			continue
+		}
+		r := bytes.NewReader(buf[3:n])
+		from, err := readAddr(r)
+		if err != nil {
+			continue
+		}
+		data := buf[n-r.Len() : n]
+		var addr net.Addr = from
+		if from.IP != nil {
+			addr = &net.UDPAddr{IP: from.IP, Port: from.Port}
+		}
+		return copy(p, data), addr, nil
+	}
+}
+
+func (c *PacketConn) Close() error {
+	err := c.UDPConn.Close()
+	_ = c.control.Close()
+	return err
+}
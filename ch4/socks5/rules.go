@@ -0,0 +1,91 @@
+package socks5
+
+import (
+	"fmt"
+	"net"
+	"networkProgram/ch4/proxy"
+	"strconv"
+	"strings"
+)
+
+// Rule allows or denies access to destinations. Host is one of:
+//
+//	""  or "*"      any destination
+//	192.0.2.1       an IP address
+//	192.0.2.0/24    a network
+//	example.com     a domain name, as requested by the client
+//	*.example.com   any subdomain of example.com
+//
+// IP and network rules apply to domain names too, after the server has
+// resolved them. Port 0 matches any port.
+type Rule struct {
+	Allow bool
+	Host  string
+	Port  int
+}
+
+// ParseRule parses a rule written as host or host:port, like
+// "10.0.0.0/8", "*.example.com:443", "[2001:db8::1]:22" or "*:25".
+func ParseRule(allow bool, s string) (Rule, error) {
+	r := Rule{Allow: allow, Host: s}
+	// IPv6地址本身包含冒号，只有加上方括号才能带端口
+	if host, port, err := net.SplitHostPort(s); err == nil {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid port in rule %q", s)
+		}
+		r.Host, r.Port = host, int(p)
+	}
+	if strings.Contains(r.Host, "/") {
+		_, _, err := net.ParseCIDR(r.Host)
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid network in rule %q: %w", s, err)
+		}
+	}
+	return r, nil
+}
+
+func (r Rule) String() string {
+	action := "deny"
+	if r.Allow {
+		action = "allow"
+	}
+	host := r.Host
+	if host == "" {
+		host = "*"
+	}
+	if r.Port == 0 {
+		return action + " " + host
+	}
+	return action + " " + net.JoinHostPort(host, strconv.Itoa(r.Port))
+}
+
+// matches reports whether the rule covers the destination named name
+// (empty for IP destinations), resolved to ip, on port.
+func (r Rule) matches(name string, ip net.IP, port int) bool {
+	if r.Port != 0 && r.Port != port {
+		return false
+	}
+	switch host := r.Host; {
+	case host == "" || host == "*":
+		return true
+	case strings.Contains(host, "/"):
+		_, network, err := net.ParseCIDR(host)
+		return err == nil && ip != nil && network.Contains(ip)
+	case net.ParseIP(host) != nil:
+		return ip != nil && net.ParseIP(host).Equal(ip)
+	default:
+		return name != "" && proxy.MatchHost(host, name)
+	}
+}
+
+// allowed applies rules in order; the first rule that matches decides.
+// Destinations no rule matches are allowed.
+func allowed(rules []Rule, name string, ip net.IP, port int) bool {
+	for _, r := range rules {
+		if r.matches(name, ip, port) {
+			return r.Allow
+		}
+	}
+	return true
+}
@@ -0,0 +1,302 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"networkProgram/ch3"
+	"networkProgram/ch4/proxy"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// handshakeTimeout bounds the time a client has to authenticate and send
+// its request.
+const handshakeTimeout = 10 * time.Second
+
+// Server is a SOCKS5 server supporting the CONNECT and UDP ASSOCIATE
+// commands.
+type Server struct {
+	// Users maps usernames to passwords. If it's empty, clients don't
+	// need to authenticate.
+	Users map[string]string
+
+	// Rules decide which destinations clients may reach. See Rule.
+	Rules []Rule
+
+	DialTimeout time.Duration // the time allowed to connect to a destination; 0 means no limit
+	IdleTimeout time.Duration // close connections idle in both directions this long; 0 means never
+
+	// Dial connects to destinations. It defaults to net.Dialer's DialContext.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// ErrorLog, if set, receives messages about failed connections.
+	ErrorLog *log.Logger
+}
+
+// ListenAndServe listens on the TCP address addr and serves clients until
+// ctx is canceled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("binding to tcp %s: %w", addr, err)
+	}
+	return s.Serve(ctx, l)
+}
+
+// Serve accepts clients on l until ctx is canceled or Accept fails. Serve
+// closes l and waits for the connections it accepted to finish.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+			defer stop()
+			err := s.handle(ctx, conn)
+			if err != nil && s.ErrorLog != nil {
+				s.ErrorLog.Printf("[%s] %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	r := bufio.NewReader(conn)
+
+	err := s.authenticate(r, conn)
+	if err != nil {
+		return err
+	}
+
+	// VER | CMD | RSV | ATYP | DST.ADDR | DST.PORT
+	var hdr [3]byte
+	_, err = io.ReadFull(r, hdr[:])
+	if err != nil {
+		return err
+	}
+	if hdr[0] != version {
+		return ErrVersion
+	}
+	dst, err := readAddr(r)
+	if err != nil {
+		var rep ReplyError
+		if errors.As(err, &rep) {
+			_ = writeReply(conn, rep, Addr{})
+		}
+		return err
+	}
+
+	switch hdr[1] {
+	case cmdConnect:
+		return s.connect(ctx, conn, r, dst)
+	case cmdUDPAssociate:
+		return s.associate(ctx, conn, dst)
+	default:
+		_ = writeReply(conn, replyCommandNotSupported, Addr{})
+		return fmt.Errorf("command %d: %w", hdr[1], replyCommandNotSupported)
+	}
+}
+
+// authenticate negotiates the authentication method and, if the server
+// has users, checks the client's username and password.
+func (s *Server) authenticate(r *bufio.Reader, w io.Writer) error {
+	// VER | NMETHODS | METHODS
+	var hdr [2]byte
+	_, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return err
+	}
+	if hdr[0] != version {
+		return ErrVersion
+	}
+	methods := make([]byte, hdr[1])
+	_, err = io.ReadFull(r, methods)
+	if err != nil {
+		return err
+	}
+
+	want := byte(methodNone)
+	if len(s.Users) > 0 {
+		want = methodPassword
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == want
+	}
+	if !offered {
+		_, _ = w.Write([]byte{version, methodNoAcceptable})
+		return ErrNoMethod
+	}
+	_, err = w.Write([]byte{version, want})
+	if err != nil || want == methodNone {
+		return err
+	}
+
+	// VER | ULEN | UNAME | PLEN | PASSWD
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b != authVersion {
+		return fmt.Errorf("authentication version %d: %w", b, ErrVersion)
+	}
+	user, err := readString(r)
+	if err != nil {
+		return err
+	}
+	pass, err := readString(r)
+	if err != nil {
+		return err
+	}
+	password, ok := s.Users[user]
+	if !ok || subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+		_, _ = w.Write([]byte{authVersion, 1})
+		return fmt.Errorf("user %q: %w", user, ErrAuthFailed)
+	}
+	_, err = w.Write([]byte{authVersion, 0})
+	return err
+}
+
+// readString reads a string prefixed with its 1-byte length.
+func readString(r *bufio.Reader) (string, error) {
+	n, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return string(b), err
+}
+
+// resolve returns the addresses of dst the rules allow. It returns
+// replyNotAllowed if there are none.
+func (s *Server) resolve(ctx context.Context, dst Addr) ([]net.IP, error) {
+	ips := []net.IP{dst.IP}
+	if dst.IP == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, dst.Name)
+		if err != nil {
+			return nil, replyHostUnreachable
+		}
+		ips = ips[:0]
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+
+	// 先检查规则再连接，拨号时直接使用检查过的IP，避免再次解析得到不同的地址
+	var permitted []net.IP
+	for _, ip := range ips {
+		if allowed(s.Rules, dst.Name, ip, dst.Port) {
+			permitted = append(permitted, ip)
+		}
+	}
+	if len(permitted) == 0 {
+		return nil, replyNotAllowed
+	}
+	return permitted, nil
+}
+
+func (s *Server) connect(ctx context.Context, conn net.Conn, r *bufio.Reader, dst Addr) error {
+	ips, err := s.resolve(ctx, dst)
+	if err != nil {
+		_ = writeReply(conn, err.(ReplyError), Addr{})
+		return fmt.Errorf("CONNECT %s: %w", dst, err)
+	}
+
+	dialCtx := ctx
+	if s.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, s.DialTimeout)
+		defer cancel()
+	}
+	var upstream net.Conn
+	for _, ip := range ips {
+		upstream, err = s.dial(dialCtx, "tcp", net.JoinHostPort(ip.String(), fmt.Sprint(dst.Port)))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		_ = writeReply(conn, dialReply(err), Addr{})
+		return fmt.Errorf("CONNECT %s: %w", dst, err)
+	}
+
+	err = writeReply(conn, replySucceeded, addrFromNet(upstream.LocalAddr()))
+	if err != nil {
+		_ = upstream.Close()
+		return err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	client := conn
+	if r.Buffered() > 0 {
+		// 客户端可能在收到应答之前就发送了数据
+		client = &bufferedConn{Conn: conn, r: r}
+	}
+	stop := context.AfterFunc(ctx, func() { _ = upstream.Close() })
+	defer stop()
+	_, _, err = proxy.Splice(client, upstream, s.IdleTimeout, nil, nil)
+	return err
+}
+
+func (s *Server) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if s.Dial != nil {
+		return s.Dial(ctx, network, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// dialReply picks the reply that best describes a failed dial.
+func dialReply(err error) ReplyError {
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return replyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return replyNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return replyHostUnreachable
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return replyTTLExpired
+	default:
+		return replyGeneralFailure
+	}
+}
+
+// bufferedConn reads what the handshake's bufio.Reader already buffered
+// before reading from the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *bufferedConn) CloseWrite() error { return ch3.CloseWrite(c.Conn) }
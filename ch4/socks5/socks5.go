@@ -0,0 +1,200 @@
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	version     = 5
+	authVersion = 1 // the username/password subnegotiation version
+)
+
+// Authentication methods.
+const (
+	methodNone         = 0x00
+	methodPassword     = 0x02
+	methodNoAcceptable = 0xff
+)
+
+// Commands.
+const (
+	cmdConnect      = 0x01
+	cmdBind         = 0x02
+	cmdUDPAssociate = 0x03
+)
+
+// Address types.
+const (
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+)
+
+// Reply codes.
+const (
+	replySucceeded ReplyError = iota
+	replyGeneralFailure
+	replyNotAllowed
+	replyNetworkUnreachable
+	replyHostUnreachable
+	replyConnectionRefused
+	replyTTLExpired
+	replyCommandNotSupported
+	replyAddressNotSupported
+)
+
+var (
+	ErrVersion    = errors.New("unsupported SOCKS version")
+	ErrAuthFailed = errors.New("authentication failed")
+	ErrNoMethod   = errors.New("no acceptable authentication method")
+)
+
+// ReplyError is a failure reply from a SOCKS server.
+type ReplyError byte
+
+func (r ReplyError) Error() string {
+	switch r {
+	case replyGeneralFailure:
+		return "general SOCKS server failure"
+	case replyNotAllowed:
+		return "connection not allowed by ruleset"
+	case replyNetworkUnreachable:
+		return "network unreachable"
+	case replyHostUnreachable:
+		return "host unreachable"
+	case replyConnectionRefused:
+		return "connection refused"
+	case replyTTLExpired:
+		return "TTL expired"
+	case replyCommandNotSupported:
+		return "command not supported"
+	case replyAddressNotSupported:
+		return "address type not supported"
+	default:
+		return fmt.Sprintf("unknown reply %d", byte(r))
+	}
+}
+
+// Addr is a SOCKS address: an IP address or a domain name, and a port.
+// It implements net.Addr.
+type Addr struct {
+	IP   net.IP
+	Name string // set instead of IP for domain names
+	Port int
+}
+
+func (a Addr) Network() string { return "socks5" }
+
+func (a Addr) String() string {
+	host := a.Name
+	if a.IP != nil {
+		host = a.IP.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(a.Port))
+}
+
+// ParseAddr parses a "host:port" address. Hosts that aren't IP addresses
+// are kept as domain names for the server to resolve.
+func ParseAddr(s string) (Addr, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return Addr{}, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return Addr{}, fmt.Errorf("invalid port %q", port)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return Addr{IP: ip, Port: int(p)}, nil
+	}
+	if len(host) == 0 || len(host) > 255 {
+		return Addr{}, fmt.Errorf("invalid host %q", host)
+	}
+	return Addr{Name: host, Port: int(p)}, nil
+}
+
+func addrFromNet(a net.Addr) Addr {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return Addr{IP: a.IP, Port: a.Port}
+	case *net.UDPAddr:
+		return Addr{IP: a.IP, Port: a.Port}
+	case Addr:
+		return a
+	}
+	addr, _ := ParseAddr(a.String())
+	return addr
+}
+
+// appendAddr appends the wire form of a: ATYP | DST.ADDR | DST.PORT.
+func appendAddr(b []byte, a Addr) []byte {
+	switch {
+	case a.IP.To4() != nil:
+		b = append(b, atypIPv4)
+		b = append(b, a.IP.To4()...)
+	case a.IP != nil:
+		b = append(b, atypIPv6)
+		b = append(b, a.IP.To16()...)
+	default:
+		b = append(b, atypDomain, byte(len(a.Name)))
+		b = append(b, a.Name...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(a.Port))
+}
+
+// readAddr reads an address in wire form. An unknown address type is
+// returned as replyAddressNotSupported.
+func readAddr(r io.Reader) (Addr, error) {
+	var atyp [1]byte
+	_, err := io.ReadFull(r, atyp[:])
+	if err != nil {
+		return Addr{}, err
+	}
+
+	var a Addr
+	switch atyp[0] {
+	case atypIPv4, atypIPv6:
+		size := net.IPv4len
+		if atyp[0] == atypIPv6 {
+			size = net.IPv6len
+		}
+		a.IP = make(net.IP, size)
+		_, err = io.ReadFull(r, a.IP)
+	case atypDomain:
+		var n [1]byte
+		_, err = io.ReadFull(r, n[:])
+		if err != nil {
+			return Addr{}, err
+		}
+		name := make([]byte, n[0])
+		_, err = io.ReadFull(r, name)
+		a.Name = string(name)
+	default:
+		return Addr{}, replyAddressNotSupported
+	}
+	if err != nil {
+		return Addr{}, err
+	}
+
+	var port [2]byte
+	_, err = io.ReadFull(r, port[:])
+	if err != nil {
+		return Addr{}, err
+	}
+	a.Port = int(binary.BigEndian.Uint16(port[:]))
+	return a, nil
+}
+
+// writeReply sends a reply with the bound address bnd.
+func writeReply(w io.Writer, rep ReplyError, bnd Addr) error {
+	if bnd.IP == nil && bnd.Name == "" {
+		bnd.IP = net.IPv4zero
+	}
+	_, err := w.Write(appendAddr([]byte{version, byte(rep), 0}, bnd))
+	return err
+}
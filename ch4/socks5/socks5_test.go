@@ -0,0 +1,246 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T, s *Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = s.Serve(ctx, l)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return l.Addr().String()
+}
+
+// echoServer echoes every TCP connection on network's loopback address.
+func echoServer(t *testing.T, network, addr string) net.Listener {
+	t.Helper()
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		t.Skipf("listening on %s: %v", addr, err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+func roundTrip(t *testing.T, c *Client, addr string) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := c.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	msg := []byte("ping through socks")
+	_, err = conn.Write(msg)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Errorf("expected %q; actual %q", msg, buf)
+	}
+	return nil
+}
+
+func TestConnect(t *testing.T) {
+	proxyAddr := startServer(t, &Server{})
+	c := &Client{Proxy: proxyAddr}
+
+	v4 := echoServer(t, "tcp4", "127.0.0.1:")
+	_, port, _ := net.SplitHostPort(v4.Addr().String())
+	for _, addr := range []string{v4.Addr().String(), net.JoinHostPort("localhost", port)} {
+		if err := roundTrip(t, c, addr); err != nil {
+			t.Errorf("%s: %v", addr, err)
+		}
+	}
+
+	t.Run("IPv6", func(t *testing.T) {
+		v6 := echoServer(t, "tcp6", "[::1]:")
+		if err := roundTrip(t, c, v6.Addr().String()); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// 目标端口没有监听
+	l, _ := net.Listen("tcp", "127.0.0.1:")
+	closed := l.Addr().String()
+	_ = l.Close()
+	err := roundTrip(t, c, closed)
+	if !errors.Is(err, replyConnectionRefused) {
+		t.Errorf("expected connection refused; actual %v", err)
+	}
+
+	_, _, err = c.handshake(context.Background(), cmdBind, Addr{IP: net.IPv4zero})
+	if !errors.Is(err, replyCommandNotSupported) {
+		t.Errorf("expected command not supported for BIND; actual %v", err)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	proxyAddr := startServer(t, &Server{Users: map[string]string{"alice": "secret"}})
+	target := echoServer(t, "tcp", "127.0.0.1:").Addr().String()
+
+	testCases := []struct {
+		user, pass string
+		expected   error
+	}{
+		{"", "", ErrNoMethod},
+		{"alice", "wrong", ErrAuthFailed},
+		{"bob", "secret", ErrAuthFailed},
+		{"alice", "secret", nil},
+	}
+	for i, c := range testCases {
+		err := roundTrip(t, &Client{Proxy: proxyAddr, Username: c.user, Password: c.pass}, target)
+		if !errors.Is(err, c.expected) {
+			t.Errorf("%d: expected %v; actual %v", i, c.expected, err)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	allowed := echoServer(t, "tcp", "127.0.0.1:").Addr().String()
+	denied := echoServer(t, "tcp", "127.0.0.1:").Addr().String()
+	_, deniedPort, _ := net.SplitHostPort(denied)
+
+	deny, err := ParseRule(false, "127.0.0.0/8:"+deniedPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyAddr := startServer(t, &Server{Rules: []Rule{
+		deny,
+		{Allow: true, Host: "127.0.0.1"},
+		{Allow: false}, // everything else
+	}})
+	c := &Client{Proxy: proxyAddr}
+
+	if err := roundTrip(t, c, allowed); err != nil {
+		t.Errorf("allowed: %v", err)
+	}
+	for _, addr := range []string{denied, net.JoinHostPort("localhost", deniedPort), "192.0.2.1:80"} {
+		if err := roundTrip(t, c, addr); !errors.Is(err, replyNotAllowed) {
+			t.Errorf("%s: expected not allowed; actual %v", addr, err)
+		}
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	ip := net.ParseIP("192.0.2.10")
+	testCases := []struct {
+		rule     string
+		name     string
+		port     int
+		expected bool
+	}{
+		{"*", "", 80, true},
+		{"*:25", "", 80, false},
+		{"192.0.2.10", "", 80, true},
+		{"192.0.2.0/24:80", "example.com", 80, true},
+		{"198.51.100.0/24", "", 80, false},
+		{"example.com", "EXAMPLE.com", 443, true},
+		{"example.com", "", 443, false},
+		{"*.example.com", "www.example.com", 443, true},
+		{"*.example.com", "example.com", 443, false},
+	}
+	for _, c := range testCases {
+		r, err := ParseRule(true, c.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := r.matches(c.name, ip, c.port); actual != c.expected {
+			t.Errorf("%s matching %q port %d: expected %t", r, c.name, c.port, c.expected)
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "host:http"} {
+		if _, err := ParseRule(true, bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestUDPAssociate(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	c := &Client{Proxy: startServer(t, &Server{})}
+	conn, err := c.ListenPacket(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, port, _ := net.SplitHostPort(echo.LocalAddr().String())
+	p, _ := strconv.Atoi(port)
+	// 第二次发送到localhost时使用缓存的解析结果
+	named := Addr{Name: "localhost", Port: p}
+	for _, target := range []net.Addr{echo.LocalAddr(), named, named} {
+		msg := []byte("ping to " + target.String())
+		_, err = conn.WriteTo(msg, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 1024)
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		if from.String() != echo.LocalAddr().String() {
+			t.Errorf("%s: reply from %s", target, from)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Errorf("%s: expected %q; actual %q", target, msg, buf[:n])
+		}
+	}
+}
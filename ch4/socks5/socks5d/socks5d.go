@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"networkProgram/ch4/socks5"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+var (
+	listen = flag.String("l", "127.0.0.1:1080", "listen address")
+	users  = make(map[string]string)
+	rules  []socks5.Rule
+)
+
+func init() {
+	flag.Func("user", "allow `user:password` to connect; repeatable (default no authentication)",
+		func(s string) error {
+			user, pass, ok := strings.Cut(s, ":")
+			if !ok || user == "" {
+				return errors.New("expected user:password")
+			}
+			users[user] = pass
+			return nil
+		})
+	// 规则按照命令行中的顺序匹配
+	flag.Func("allow", "allow destinations matching `host[:port]`; repeatable", func(s string) error {
+		return addRule(true, s)
+	})
+	flag.Func("deny", "deny destinations matching `host[:port]`; repeatable", func(s string) error {
+		return addRule(false, s)
+	})
+}
+
+func addRule(allow bool, s string) error {
+	r, err := socks5.ParseRule(allow, s)
+	if err != nil {
+		return err
+	}
+	rules = append(rules, r)
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	s := &socks5.Server{
+		Users:       users,
+		Rules:       rules,
+		DialTimeout: 10 * time.Second,
+		IdleTimeout: 5 * time.Minute,
+		ErrorLog:    log.Default(),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	log.Printf("SOCKS5 server listening on %s", *listen)
+	for _, r := range rules {
+		log.Printf("rule: %s", r)
+	}
+	err := s.ListenAndServe(ctx, *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,210 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	maxDatagram = 64 << 10 // the largest UDP datagram the relay handles
+	nameTTL     = 30 * time.Second
+	maxWaiting  = 16 // datagrams held per name while it's being resolved
+)
+
+// associate serves UDP ASSOCIATE. The relay accepts datagrams from the
+// client's IP address, and port if the request named one, and forwards
+// replies only from destinations the client has sent to. It lasts as long
+// as the TCP connection conn.
+func (s *Server) associate(ctx context.Context, conn net.Conn, expected Addr) error {
+	local := conn.LocalAddr().(*net.TCPAddr)
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP})
+	if err != nil {
+		_ = writeReply(conn, replyGeneralFailure, Addr{})
+		return fmt.Errorf("UDP ASSOCIATE: %w", err)
+	}
+	defer relay.Close()
+
+	err = writeReply(conn, replySucceeded, addrFromNet(relay.LocalAddr()))
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	// TCP连接关闭时关联结束
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		_ = relay.Close()
+	}()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { _ = relay.Close() })
+	defer stop()
+
+	a := &association{
+		s:        s,
+		relay:    relay,
+		clientIP: conn.RemoteAddr().(*net.TCPAddr).IP,
+		port:     expected.Port,
+		peers:    make(map[string]bool),
+		names:    make(map[string]*lookup),
+	}
+	a.serve(ctx)
+	return nil
+}
+
+type association struct {
+	s        *Server
+	relay    *net.UDPConn
+	clientIP net.IP
+	port     int // the client's UDP port, if it said in advance
+
+	mu     sync.Mutex
+	client *net.UDPAddr       // where replies go, once the client has sent something
+	peers  map[string]bool    // destinations the client has sent to
+	names  map[string]*lookup // resolved destination names
+}
+
+// lookup is a destination name being resolved, or resolved within nameTTL.
+type lookup struct {
+	ips     []net.IP
+	err     error
+	done    bool
+	expires time.Time
+	waiting []datagram // datagrams to send once the name is resolved
+}
+
+type datagram struct {
+	from *net.UDPAddr
+	p    []byte
+}
+
+func (a *association) serve(ctx context.Context) {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, from, err := a.relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if a.fromClient(from) {
+			a.forward(ctx, from, buf[:n])
+		} else {
+			a.reply(from, buf[:n])
+		}
+	}
+}
+
+func (a *association) fromClient(from *net.UDPAddr) bool {
+	if !from.IP.Equal(a.clientIP) {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.client != nil {
+		return from.Port == a.client.Port
+	}
+	return a.port == 0 || from.Port == a.port
+}
+
+// forward sends a datagram from the client to its destination. Names are
+// resolved in the background, so a slow DNS server doesn't hold up the
+// relay; datagrams for a name arriving meanwhile wait for the result.
+func (a *association) forward(ctx context.Context, from *net.UDPAddr, p []byte) {
+	// RSV | FRAG | ATYP | DST.ADDR | DST.PORT | DATA
+	if len(p) < 4 || p[2] != 0 {
+		return // 不支持分片，直接丢弃
+	}
+	r := bytes.NewReader(p[3:])
+	dst, err := readAddr(r)
+	if err != nil {
+		return
+	}
+	p = p[len(p)-r.Len():]
+	if dst.IP != nil {
+		// IP地址不需要解析，只检查规则
+		ips, err := a.s.resolve(ctx, dst)
+		a.send(from, dst, ips, err, p)
+		return
+	}
+
+	a.mu.Lock()
+	l, ok := a.names[dst.Name]
+	switch {
+	case ok && l.done && time.Now().Before(l.expires):
+		a.mu.Unlock()
+		a.send(from, dst, l.ips, l.err, p)
+		return
+	case ok && !l.done:
+		if len(l.waiting) < maxWaiting {
+			l.waiting = append(l.waiting, datagram{from, bytes.Clone(p)})
+		}
+		a.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	for name, old := range a.names {
+		if old.done && now.After(old.expires) {
+			delete(a.names, name) // 清理过期的结果，免得缓存无限增长
+		}
+	}
+	l = &lookup{waiting: []datagram{{from, bytes.Clone(p)}}}
+	a.names[dst.Name] = l
+	a.mu.Unlock()
+
+	go func() {
+		ips, err := a.s.resolve(ctx, dst)
+		a.mu.Lock()
+		l.ips, l.err, l.done, l.expires = ips, err, true, time.Now().Add(nameTTL)
+		waiting := l.waiting
+		l.waiting = nil
+		a.mu.Unlock()
+		for _, d := range waiting {
+			a.send(d.from, dst, ips, err, d.p)
+		}
+	}()
+}
+
+// send sends p from the client to dst, resolved to ips or failed with err.
+func (a *association) send(from *net.UDPAddr, dst Addr, ips []net.IP, err error, p []byte) {
+	if err != nil {
+		if a.s.ErrorLog != nil {
+			a.s.ErrorLog.Printf("[%s] UDP %s: %v", from, dst, err)
+		}
+		return
+	}
+
+	// 中继套接字只能发送到与其相同地址族的目标
+	v4 := a.relay.LocalAddr().(*net.UDPAddr).IP.To4() != nil
+	var to *net.UDPAddr
+	for _, ip := range ips {
+		if (ip.To4() != nil) == v4 {
+			to = &net.UDPAddr{IP: ip, Port: dst.Port}
+			break
+		}
+	}
+	if to == nil {
+		return
+	}
+	a.mu.Lock()
+	a.client = from
+	a.peers[to.String()] = true
+	a.mu.Unlock()
+	_, _ = a.relay.WriteToUDP(p, to)
+}
+
+// reply wraps a datagram from a destination and sends it to the client.
+func (a *association) reply(from *net.UDPAddr, p []byte) {
+	a.mu.Lock()
+	client := a.client
+	ok := a.peers[from.String()]
+	a.mu.Unlock()
+	if !ok || client == nil {
+		return
+	}
+	hdr := appendAddr([]byte{0, 0, 0}, Addr{IP: from.IP, Port: from.Port})
+	_, _ = a.relay.WriteToUDP(append(hdr, p...), client)
+}
@@ -0,0 +1,111 @@
+package ch5
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"networkProgram/ch3"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEchoServerUDPReusePort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serverAddr, served, err := echoServerUDPN(ctx, "127.0.0.1:", 4, ch3.SockOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 每个客户端使用不同的源端口，内核据此把数据报分配到各个套接字
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client, err := net.Dial("udp", serverAddr.String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer client.Close()
+			_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+
+			msg := []byte(fmt.Sprintf("ping %d", i))
+			if _, err = client.Write(msg); err != nil {
+				t.Error(err)
+				return
+			}
+			buf := make([]byte, 1024)
+			n, err := client.Read(buf)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(msg, buf[:n]) {
+				t.Errorf("expected reply %q; actual reply %q", msg, buf[:n])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 内核应当把流量分散到多个套接字上，而不是全部交给一个
+	var counts []int64
+	busy := 0
+	for _, n := range served {
+		counts = append(counts, n.Load())
+		if n.Load() > 0 {
+			busy++
+		}
+	}
+	if busy < 2 {
+		t.Errorf("expected datagrams on more than one socket; actual counts %v", counts)
+	}
+
+	if _, _, err = echoServerUDPN(ctx, "127.0.0.1:", 0, ch3.SockOpts{}); err == nil {
+		t.Error("expected an error for 0 sockets")
+	}
+}
+
+// BenchmarkEchoServerUDPReusePort measures datagram round trips with 1, 2,
+// 4 and 8 read loops. Each parallel client has its own source port.
+func BenchmarkEchoServerUDPReusePort(b *testing.B) {
+	msg := bytes.Repeat([]byte("x"), 512)
+	for _, n := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("sockets=%d", n), func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			serverAddr, _, err := echoServerUDPN(ctx, "127.0.0.1:", n, ch3.SockOpts{})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(int64(len(msg)))
+			b.SetParallelism(4) // 足够多的客户端才能分散到各个套接字
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				client, err := net.Dial("udp", serverAddr.String())
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				defer client.Close()
+				buf := make([]byte, 1024)
+				for pb.Next() {
+					// 丢失的数据报不应让基准测试挂起
+					_ = client.SetDeadline(time.Now().Add(time.Second))
+					if _, err = client.Write(msg); err != nil {
+						b.Error(err)
+						return
+					}
+					if _, err = client.Read(buf); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package ch5
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"networkProgram/ch4/socks5"
+	"testing"
+	"time"
+)
+
+func TestEchoServerUDPSocks5(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serverAddr, err := echoServerUDP(ctx, "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socks5.Server{Users: map[string]string{"user": "pass"}}
+	go func() { _ = s.Serve(ctx, l) }()
+
+	// 通过SOCKS5代理的UDP中继访问回显服务器
+	c := &socks5.Client{Proxy: l.Addr().String(), Username: "user", Password: "pass"}
+	client, err := c.ListenPacket(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	msg := []byte("ping")
+	_, err = client.WriteTo(msg, serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	n, addr, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addr.String() != serverAddr.String() {
+		t.Fatalf("received reply from %q instead of %q", addr, serverAddr)
+	}
+	if !bytes.Equal(msg, buf[:n]) {
+		t.Errorf("expected reply %q; actual reply %q", msg, buf[:n])
+	}
+}
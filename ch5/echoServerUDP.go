@@ -4,14 +4,73 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"networkProgram/ch3"
+	"sync/atomic"
 )
 
 func echoServerUDP(ctx context.Context, addr string) (net.Addr, error) {
-	s, err := net.ListenPacket("udp", addr)
+	return echoServerUDPOpts(ctx, addr, ch3.SockOpts{})
+}
+
+// echoServerUDPOpts is echoServerUDP with socket options, such as bigger
+// buffers, set on its socket.
+func echoServerUDPOpts(ctx context.Context, addr string, opts ch3.SockOpts) (net.Addr, error) {
+	s, err := opts.ListenPacket(ctx, "udp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("binding to udp %s: %w", addr, err)
 	}
 
+	serveEchoUDP(ctx, s)
+	return s.LocalAddr(), nil
+}
+
+// echoServerUDPN binds n sockets to addr with SO_REUSEPORT, each with its
+// own read loop, so the kernel spreads datagrams across them by source
+// address. A zero port in addr is resolved by the first socket. The i-th
+// counter returned counts the datagrams socket i received.
+func echoServerUDPN(ctx context.Context, addr string, n int, opts ch3.SockOpts) (net.Addr, []*atomic.Int64, error) {
+	if n < 1 {
+		return nil, nil, fmt.Errorf("need at least 1 socket; got %d", n)
+	}
+	opts.ReusePort = true
+	conns := make([]net.PacketConn, 0, n)
+	for i := 0; i < n; i++ {
+		s, err := opts.ListenPacket(ctx, "udp", addr)
+		if err != nil {
+			for _, c := range conns {
+				_ = c.Close()
+			}
+			return nil, nil, fmt.Errorf("binding socket %d of %d to udp %s: %w", i+1, n, addr, err)
+		}
+		conns = append(conns, s)
+		addr = s.LocalAddr().String()
+	}
+
+	received := make([]*atomic.Int64, n)
+	for i, s := range conns {
+		received[i] = new(atomic.Int64)
+		serveEchoUDP(ctx, &countingPacketConn{PacketConn: s, received: received[i]})
+	}
+	return conns[0].LocalAddr(), received, nil
+}
+
+// countingPacketConn counts the datagrams it reads.
+type countingPacketConn struct {
+	net.PacketConn
+	received *atomic.Int64
+}
+
+func (c *countingPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if err == nil {
+		c.received.Add(1)
+	}
+	return n, addr, err
+}
+
+// serveEchoUDP echoes every datagram s receives back to its sender until
+// ctx is canceled.
+func serveEchoUDP(ctx context.Context, s net.PacketConn) {
 	go func() {
 		go func() {
 			<-ctx.Done()
@@ -33,5 +92,4 @@ func echoServerUDP(ctx context.Context, addr string) (net.Addr, error) {
 			}
 		}
 	}()
-	return s.LocalAddr(), nil
 }
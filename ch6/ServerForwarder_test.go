@@ -0,0 +1,86 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"networkProgram/ch4/proxy"
+	"testing"
+	"time"
+)
+
+func TestServerBehindUDPForwarder(t *testing.T) {
+	payload := bytes.Repeat([]byte("tftp"), 300) // 1200 bytes, three blocks
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := &Server{Payload: payload, Timeout: time.Second}
+	go func() { _ = s.Server(conn) }()
+
+	fwd, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	closed := make(chan proxy.UDPStats, 1)
+	f := &proxy.UDPForwarder{
+		Upstream:    conn.LocalAddr().String(),
+		IdleTimeout: 200 * time.Millisecond,
+		OnClose:     func(s proxy.UDPStats) { closed <- s },
+	}
+	go func() { _ = f.Serve(ctx, fwd) }()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	rrq, err := ReadReq{Filename: "test", Mode: "octet"}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.WriteTo(rrq, fwd.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 客户端始终只和转发器通信，转发器负责把ACK发往服务器的传输端口
+	received := new(bytes.Buffer)
+	buf := make([]byte, DatagramSize)
+	for {
+		_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, addr, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data Data
+		if err := data.UnmarshalBinary(buf[:n]); err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(received, data.Payload)
+		ack, _ := Ack(data.Block).MarshalBinary()
+		if _, err := client.WriteTo(ack, addr); err != nil {
+			t.Fatal(err)
+		}
+		if n < DatagramSize {
+			break
+		}
+	}
+	if !bytes.Equal(received.Bytes(), payload) {
+		t.Fatalf("expected %d bytes; actual %d", len(payload), received.Len())
+	}
+
+	select {
+	case stats := <-closed:
+		if stats.PacketsSent != 4 || stats.PacketsReceived != 3 {
+			t.Errorf("expected 4 packets sent and 3 received; actual %+v", stats)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("session did not expire")
+	}
+}
@@ -0,0 +1,74 @@
+package echo
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"networkProgram/ch4/proxy"
+	"networkProgram/ch4/proxyproto"
+	"testing"
+	"time"
+)
+
+func TestEchoServerProxyProtocol(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Addr, 1)
+	serveEcho(ctx, &remoteAddrListener{
+		Listener: &proxyproto.Listener{Listener: l, ReadHeaderTimeout: time.Second},
+		accepted: accepted,
+	})
+
+	// 代理在转发客户端数据之前先发送PROXY协议头部
+	p := &proxy.Proxy{Upstream: l.Addr().String(), ProxyProtocol: 2}
+	pl, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = p.Serve(ctx, pl) }()
+
+	conn, err := net.Dial("tcp", pl.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	msg := []byte("ping")
+	_, err = conn.Write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, buf[:n]) {
+		t.Fatalf("expected reply %q; actual reply %q", msg, buf[:n])
+	}
+
+	// the echo server sees the client's address, not the proxy's
+	if addr := <-accepted; addr.String() != conn.LocalAddr().String() {
+		t.Fatalf("expected remote address %s; actual %s", conn.LocalAddr(), addr)
+	}
+}
+
+// remoteAddrListener reports the RemoteAddr of each accepted connection.
+type remoteAddrListener struct {
+	net.Listener
+	accepted chan net.Addr
+}
+
+func (l *remoteAddrListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	go func() { l.accepted <- conn.RemoteAddr() }()
+	return conn, nil
+}
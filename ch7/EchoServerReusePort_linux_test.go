@@ -0,0 +1,106 @@
+package echo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"networkProgram/ch3"
+	"sync"
+	"testing"
+)
+
+func TestEchoServerReusePort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, served, err := streamingEchoServerN(ctx, "tcp", "127.0.0.1:", 4, ch3.SockOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", addr.String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+
+			msg := []byte(fmt.Sprintf("ping %d", i))
+			if _, err = conn.Write(msg); err != nil {
+				t.Error(err)
+				return
+			}
+			buf := make([]byte, len(msg))
+			if _, err = io.ReadFull(conn, buf); err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(msg, buf) {
+				t.Errorf("expected reply %q; actual reply %q", msg, buf)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 内核应当把流量分散到多个套接字上，而不是全部交给一个
+	var counts []int64
+	busy := 0
+	for _, n := range served {
+		counts = append(counts, n.Load())
+		if n.Load() > 0 {
+			busy++
+		}
+	}
+	if busy < 2 {
+		t.Errorf("expected connections on more than one socket; actual counts %v", counts)
+	}
+
+	if _, _, err = streamingEchoServerN(ctx, "tcp", "127.0.0.1:", 0, ch3.SockOpts{}); err == nil {
+		t.Error("expected an error for 0 listeners")
+	}
+}
+
+// BenchmarkEchoServerReusePort measures how many short-lived connections
+// the echo server handles with 1, 2, 4 and 8 accept loops.
+func BenchmarkEchoServerReusePort(b *testing.B) {
+	msg := bytes.Repeat([]byte("x"), 1024)
+	for _, n := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("listeners=%d", n), func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			addr, _, err := streamingEchoServerN(ctx, "tcp", "127.0.0.1:", n, ch3.SockOpts{})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(int64(len(msg)))
+			b.SetParallelism(4) // 足够多的客户端才能分散到各个套接字
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				buf := make([]byte, len(msg))
+				for pb.Next() {
+					conn, err := net.Dial("tcp", addr.String())
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					_, err = conn.Write(msg)
+					if err == nil {
+						_, err = io.ReadFull(conn, buf)
+					}
+					_ = conn.Close()
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
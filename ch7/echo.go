@@ -2,46 +2,95 @@ package echo
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"networkProgram/ch3"
+	"sync/atomic"
 )
 
 func streamingEchoServer(ctx context.Context, network string,
 	addr string) (net.Addr, error) {
-	s, err := net.Listen(network, addr)
+	return streamingEchoServerOpts(ctx, network, addr, ch3.SockOpts{})
+}
+
+// streamingEchoServerOpts is streamingEchoServer with socket options set
+// on the listener and the connections it accepts.
+func streamingEchoServerOpts(ctx context.Context, network string,
+	addr string, opts ch3.SockOpts) (net.Addr, error) {
+	s, err := opts.Listen(ctx, network, addr)
 	if err != nil {
 		return nil, err
 	}
 
-	go func() {
-		go func() {
-			<-ctx.Done()
-			_ = s.Close()
-		}()
+	serveEcho(ctx, s)
+	return s.Addr(), nil
+}
 
-		for { // 持续地等待客户端连接，并为每一个连接启动一个新的协程进行处理
-			conn, err := s.Accept()
-			if err != nil {
-				return
+// streamingEchoServerN binds n sockets to addr with SO_REUSEPORT, each
+// served by its own accept loop, so the kernel spreads new connections
+// across them. A zero port in addr is resolved by the first socket. The
+// i-th counter returned counts the connections socket i accepted.
+func streamingEchoServerN(ctx context.Context, network string,
+	addr string, n int, opts ch3.SockOpts) (net.Addr, []*atomic.Int64, error) {
+	if n < 1 {
+		return nil, nil, fmt.Errorf("need at least 1 socket; got %d", n)
+	}
+	opts.ReusePort = true
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		s, err := opts.Listen(ctx, network, addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
 			}
+			return nil, nil, fmt.Errorf("binding listener %d of %d: %w", i+1, n, err)
+		}
+		listeners = append(listeners, s)
+		addr = s.Addr().String() // 后续套接字绑定同一端口
+	}
+
+	accepted := make([]*atomic.Int64, n)
+	for i, s := range listeners {
+		accepted[i] = new(atomic.Int64)
+		serveEcho(ctx, &countingListener{Listener: s, accepted: accepted[i]})
+	}
+	return listeners[0].Addr(), accepted, nil
+}
 
-			go func() {
-				defer func() { _ = conn.Close() }()
+// countingListener counts the connections it accepts.
+type countingListener struct {
+	net.Listener
+	accepted *atomic.Int64
+}
 
-				for { //这个循环持续地从当前客户端读取数据，并将接收到的数据回显到客户端
-					buf := make([]byte, 1024)
-					n, err := conn.Read(buf)
-					if err != nil {
-						return
-					}
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
 
-					_, err = conn.Write(buf[:n])
-					if err != nil {
-						return
-					}
-				}
+// serveEcho echoes everything each client connected to s sends until ctx
+// is canceled. Taking a listener lets callers wrap it first, for example
+// with a proxyproto.Listener.
+func serveEcho(ctx context.Context, s net.Listener) {
+	srv := &ch3.Server{Handler: ch3.HandlerFunc(echo)}
+	go func() { _ = srv.Serve(ctx, s) }()
+}
 
-			}()
+// echo writes back everything the client sends until it disconnects.
+func echo(_ context.Context, conn net.Conn) {
+	buf := make([]byte, 1024)
+	for { //这个循环持续地从当前客户端读取数据，并将接收到的数据回显到客户端
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
 		}
-	}()
-	return s.Addr(), nil
+
+		_, err = conn.Write(buf[:n])
+		if err != nil {
+			return
+		}
+	}
 }
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"networkProgram/ch3"
+	"networkProgram/ch4/proxy"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Connect is an HTTP proxy handler for the CONNECT method. It hijacks the
+// client's connection and splices it to the requested host:port, so the
+// client can tunnel TLS or anything else through the proxy.
+//
+// Connect needs a connection it can hijack, so it only works over
+// HTTP/1.x. http.Server's Shutdown neither waits for nor closes hijacked
+// connections; a tunnel is closed when its request's context is done, so
+// cancel the server's BaseContext on shutdown to close the tunnels too.
+type Connect struct {
+	// Users maps usernames to passwords for Proxy-Authorization basic
+	// auth. If it's empty, clients don't need to authenticate.
+	Users map[string]string
+
+	// AllowHosts lists the hosts clients may connect to: host names, IP
+	// addresses or "*.example.com" for any subdomain. Empty allows any
+	// host.
+	AllowHosts []string
+
+	// AllowPorts lists the ports clients may connect to. Empty allows any
+	// port.
+	AllowPorts []int
+
+	DialTimeout time.Duration // the time allowed to connect to the host; 0 means no limit
+	IdleTimeout time.Duration // close tunnels idle in both directions this long; 0 means never
+
+	// Dial connects to the host. It defaults to net.Dialer's DialContext.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Log, if set, receives a line for every tunnel with the bytes it
+	// carried and how long it lasted.
+	Log *log.Logger
+}
+
+func (c *Connect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		w.Header().Set("Allow", http.MethodConnect)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !c.authorized(r) {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+		http.Error(w, "Proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if !c.allowed(host, int(p)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "CONNECT requires HTTP/1.x", http.StatusHTTPVersionNotSupported)
+		return
+	}
+
+	start := time.Now()
+	upstream, err := c.dial(r.Context(), r.Host)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		http.Error(w, http.StatusText(status), status)
+		c.logf("CONNECT %s from %s: %v", r.Host, r.RemoteAddr, err)
+		return
+	}
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		_ = upstream.Close()
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	// 接管连接后服务器设置的超时不再适用
+	_ = conn.SetDeadline(time.Time{})
+	_, err = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	if err != nil {
+		_ = conn.Close()
+		_ = upstream.Close()
+		return
+	}
+
+	client := conn
+	if brw.Reader.Buffered() > 0 {
+		// 客户端可能在收到应答之前就开始发送TLS握手
+		client = &hijackedConn{Conn: conn, r: brw.Reader}
+	}
+	stop := context.AfterFunc(r.Context(), func() {
+		_ = conn.Close()
+		_ = upstream.Close()
+	})
+	defer stop()
+	sent, received, err := proxy.Splice(client, upstream, c.IdleTimeout, nil, nil)
+	if err != nil {
+		c.logf("CONNECT %s from %s: sent %d bytes, received %d bytes in %s: %v",
+			r.Host, r.RemoteAddr, sent, received, time.Since(start).Round(time.Millisecond), err)
+		return
+	}
+	c.logf("CONNECT %s from %s: sent %d bytes, received %d bytes in %s",
+		r.Host, r.RemoteAddr, sent, received, time.Since(start).Round(time.Millisecond))
+}
+
+func (c *Connect) authorized(r *http.Request) bool {
+	if len(c.Users) == 0 {
+		return true
+	}
+	// Proxy-Authorization和Authorization格式相同，借用BasicAuth来解析
+	req := &http.Request{Header: http.Header{"Authorization": r.Header["Proxy-Authorization"]}}
+	user, pass, ok := req.BasicAuth()
+	if !ok {
+		return false
+	}
+	password, ok := c.Users[user]
+	return ok && subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+}
+
+func (c *Connect) allowed(host string, port int) bool {
+	if len(c.AllowPorts) > 0 && !slices.Contains(c.AllowPorts, port) {
+		return false
+	}
+	if len(c.AllowHosts) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(c.AllowHosts, func(pattern string) bool {
+		return proxy.MatchHost(pattern, host)
+	})
+}
+
+func (c *Connect) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if c.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.DialTimeout)
+		defer cancel()
+	}
+	if c.Dial != nil {
+		return c.Dial(ctx, "tcp", addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+func (c *Connect) logf(format string, v ...any) {
+	if c.Log != nil {
+		_ = c.Log.Output(2, fmt.Sprintf(format, v...))
+	}
+}
+
+// hijackedConn reads what the server already buffered before reading from
+// the connection.
+type hijackedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *hijackedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *hijackedConn) CloseWrite() error { return ch3.CloseWrite(c.Conn) }
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe to share with the proxy's goroutines.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+func TestConnectTransport(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "Hello through the tunnel!")
+		},
+	))
+	defer target.Close()
+
+	logs := new(syncBuffer)
+	proxySrv := httptest.NewServer(&Connect{
+		Users: map[string]string{"ci": "s3cret"},
+		Log:   log.New(logs, "", 0),
+	})
+	defer proxySrv.Close()
+
+	// http.Transport通过CONNECT访问HTTPS地址，并用URL中的用户信息生成Proxy-Authorization
+	proxyURL, _ := url.Parse(proxySrv.URL)
+	proxyURL.User = url.UserPassword("ci", "s3cret")
+	transport := target.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(target.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(b) != "Hello through the tunnel!" {
+		t.Fatalf("unexpected body %q", b)
+	}
+	transport.CloseIdleConnections()
+
+	// 隧道关闭后才会记录日志
+	expected := "CONNECT " + target.Listener.Addr().String()
+	for i := 0; i < 100 && !strings.Contains(logs.String(), expected); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !strings.Contains(logs.String(), expected) || !strings.Contains(logs.String(), "bytes in") {
+		t.Errorf("expected a tunnel log line; actual %q", logs)
+	}
+}
+
+func TestConnectRejects(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+	port, _ := strconv.Atoi(echoPort)
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:")
+	closedAddr := closed.Addr().String()
+	_ = closed.Close()
+	_, closedPort, _ := net.SplitHostPort(closedAddr)
+	cp, _ := strconv.Atoi(closedPort)
+
+	proxySrv := httptest.NewServer(&Connect{
+		Users:      map[string]string{"ci": "s3cret"},
+		AllowHosts: []string{"127.0.0.1", "*.example.com"},
+		AllowPorts: []int{port, cp},
+	})
+	defer proxySrv.Close()
+
+	const auth = "Proxy-Authorization: Basic Y2k6czNjcmV0\r\n" // ci:s3cret
+	testCases := []struct {
+		target, header string
+		status         int
+	}{
+		{echo.Addr().String(), "", http.StatusProxyAuthRequired},
+		{echo.Addr().String(), "Proxy-Authorization: Basic Y2k6d3Jvbmc=\r\n", http.StatusProxyAuthRequired},
+		{"127.0.0.1:1", auth, http.StatusForbidden},
+		{"localhost:" + echoPort, auth, http.StatusForbidden},
+		{closedAddr, auth, http.StatusBadGateway},
+		{echo.Addr().String(), auth, http.StatusOK},
+	}
+
+	for i, c := range testCases {
+		conn, err := net.Dial("tcp", proxySrv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 在收到应答之前就发送隧道中的数据，测试缓冲的数据不会丢失
+		_, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %[1]s\r\n%s\r\nping", c.target, c.header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(r, &http.Request{Method: http.MethodConnect})
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if resp.StatusCode != c.status {
+			t.Errorf("%d: expected status %d; actual %d", i, c.status, resp.StatusCode)
+		}
+		if c.status == http.StatusProxyAuthRequired && resp.Header.Get("Proxy-Authenticate") == "" {
+			t.Errorf("%d: missing Proxy-Authenticate header", i)
+		}
+		if c.status == http.StatusOK {
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(r, buf)
+			if err != nil || string(buf) != "ping" {
+				t.Errorf("%d: expected the echo %q; actual %q, %v", i, "ping", buf, err)
+			}
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestConnectClosesTunnelsOnShutdown(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	proxySrv := httptest.NewUnstartedServer(new(Connect))
+	proxySrv.Config.BaseContext = func(net.Listener) context.Context { return ctx }
+	proxySrv.Config.RegisterOnShutdown(cancel)
+	proxySrv.Start()
+	defer proxySrv.Close()
+
+	conn, err := net.Dial("tcp", proxySrv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %[1]s\r\n\r\n", echo.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d; actual %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// Shutdown不等待被接管的连接，但取消context后隧道应当关闭
+	err = proxySrv.Config.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = r.ReadByte(); err != io.EOF {
+		t.Errorf("expected the tunnel to be closed; actual %v", err)
+	}
+}
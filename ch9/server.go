@@ -2,14 +2,19 @@ package ch9
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"log"
+	"net"
 	"net/http"
+	"networkProgram/ch3"
+	"networkProgram/ch4/proxyproto"
 	"networkProgram/ch9/handlers"
 	"networkProgram/ch9/middleware"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -18,18 +23,32 @@ var (
 	cert  = flag.String("cert", "", "certificate")
 	pkey  = flag.String("key", "", "private key")
 	files = flag.String("files", "./files", "static file directory")
+	proxy = flag.Bool("proxy-protocol", false,
+		"expect a PROXY protocol header on every connection")
+	connect = flag.String("connect", "",
+		"also act as a CONNECT proxy for `user:password`; \"-\" disables authentication")
+	keepAlive = flag.Duration("tcp-keepalive", 0,
+		"idle time before TCP keepalive probes; 0 keeps Go's default")
+	userTimeout = flag.Duration("tcp-user-timeout", 0,
+		"drop connections whose sent data stays unacknowledged this long")
+	reusePort = flag.Bool("reuseport", false, "bind with SO_REUSEPORT")
 )
 
 func main() {
 	flag.Parse()
-	err := run(*addr, *files, *cert, *pkey)
+	opts := ch3.SockOpts{
+		KeepAliveIdle: *keepAlive,
+		UserTimeout:   *userTimeout,
+		ReusePort:     *reusePort,
+	}
+	err := run(*addr, *files, *cert, *pkey, *proxy, *connect, opts)
 	if err != nil {
 		log.Fatal(err)
 	}
 	log.Println("Server gracefully shutdown")
 }
 
-func run(addr, files, cert, pkey string) error {
+func run(addr, files, cert, pkey string, proxy bool, connect string, opts ch3.SockOpts) error {
 	mux := http.NewServeMux()
 	mux.Handle("/static/",
 		http.StripPrefix("/static/",
@@ -65,12 +84,51 @@ func run(addr, files, cert, pkey string) error {
 		},
 	)
 
+	var handler http.Handler = mux
+	if connect != "" {
+		c := &handlers.Connect{
+			DialTimeout: 10 * time.Second,
+			IdleTimeout: 5 * time.Minute,
+			Dial:        opts.DialContext,
+			Log:         log.Default(),
+		}
+		if connect != "-" {
+			user, pass, ok := strings.Cut(connect, ":")
+			if !ok {
+				return errors.New("-connect: expected user:password or -")
+			}
+			c.Users = map[string]string{user: pass}
+		}
+		// CONNECT请求的目标是host:port而不是路径，不能交给mux路由
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodConnect {
+				c.ServeHTTP(w, r)
+				return
+			}
+			mux.ServeHTTP(w, r)
+		})
+	}
+
 	srv := http.Server{
 		Addr:              addr,
-		Handler:           mux,
+		Handler:           handler,
 		IdleTimeout:       time.Minute,
 		ReadHeaderTimeout: 30 * time.Second,
 	}
+	// Shutdown不会关闭CONNECT隧道接管的连接，取消请求的context让隧道结束
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.BaseContext = func(net.Listener) context.Context { return ctx }
+	srv.RegisterOnShutdown(cancel)
+	l, err := opts.Listen(context.Background(), "tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	if proxy {
+		// 服务器位于负载均衡器之后时，从PROXY协议头部中获取客户端的真实地址
+		l = &proxyproto.Listener{Listener: l, ReadHeaderTimeout: srv.ReadHeaderTimeout}
+	}
+
 	done := make(chan struct{})
 	go func() {
 		c := make(chan os.Signal, 1)
@@ -86,12 +144,11 @@ func run(addr, files, cert, pkey string) error {
 		}
 	}()
 	log.Printf("Serving files in %q over %s\n", files, srv.Addr)
-	var err error
 	if cert != "" && pkey != "" {
 		log.Println("TLS enable")
-		err = srv.ListenAndServeTLS(cert, pkey)
+		err = srv.ServeTLS(l, cert, pkey)
 	} else {
-		err = srv.ListenAndServe()
+		err = srv.Serve(l)
 	}
 	if err == http.ErrServerClosed {
 		err = nil